@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// joinTimeout is how long a bot waits for its key to be registered and for
+// the lobby to let it in.
+const joinTimeout = 5 * time.Second
+
+// lobbyReplies is how many lobby replies can wait to be read. Late replies to
+// earlier requests are skipped.
+const lobbyReplies = 8
+
+// settleTimeout is how long a bot waits, after a turn result, for the update
+// to its army that comes with it on a different queue.
+const settleTimeout = 5 * time.Second
+
+// bot plays one player in a game, giving whatever orders its strategy comes
+// up with at the start of every turn.
+type bot struct {
+	username string
+	gameID   string
+	strategy gamelogic.Strategy
+}
+
+func (b *bot) key(k string) string {
+	return routing.GameKey(b.gameID, k)
+}
+
+// run joins the game and plays until it is over. Every bot has its own
+// connection, so one bot's queues never slow another down.
+func (b *bot) run(amqpURL string, registry *identity.Registry) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	username := b.username
+	signer, err := identity.LoadOrCreateSigner(username)
+	if err != nil {
+		return err
+	}
+	pubsub.AddSigner(signer)
+	defer pubsub.RemoveSigner(username)
+
+	gs := gamelogic.NewGameState(username)
+
+	hsd := pubsub.HandlerStateDelta(gs)
+	htr := pubsub.HandlerTurnResult(gs)
+	turns := make(chan gamelogic.TurnResult, 1)
+	settled := make(chan int, 1)
+
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, b.key(routing.PauseKey+"."+username), b.key(routing.PauseKey), int(amqp.Transient), pubsub.HandlerPause(gs), pubsub.UnmarshallerPlayingState())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, b.key(routing.RulesetKey+"."+username), b.key(routing.RulesetKey), int(amqp.Transient), pubsub.HandlerRuleset(gs), pubsub.UnmarshallerRuleset())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, b.key(routing.StateDeltasPrefix+"."+username), b.key(routing.StateDeltasPrefix+"."+username), int(amqp.Transient), func(sd gamelogic.StateDelta, aCh *amqp.Channel) int {
+		ack := hsd(sd, aCh)
+		// Only the latest turn matters, so an older one not yet read is
+		// replaced.
+		select {
+		case <-settled:
+		default:
+		}
+		settled <- sd.Turn
+		return ack
+	}, pubsub.UnmarshallerUnsealed(signer, b.key(routing.StateDeltasPrefix+"."+username), pubsub.UnmarshallerStateDelta()))
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, b.key(routing.TurnResultsPrefix+"."+username), b.key(routing.TurnResultsPrefix+"."+username), int(amqp.Transient), func(tr gamelogic.TurnResult, aCh *amqp.Channel) int {
+		ack := htr(tr, aCh)
+		turns <- tr
+		return ack
+	}, pubsub.UnmarshallerUnsealed(signer, b.key(routing.TurnResultsPrefix+"."+username), pubsub.UnmarshallerTurnResult()))
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, b.key(routing.TreatiesPrefix+"."+username), b.key(routing.TreatiesPrefix+"."+username), int(amqp.Persistent), pubsub.HandlerTreatyUpdate(gs), pubsub.UnmarshallerTreatyUpdate())
+	if err != nil {
+		return err
+	}
+	// Bots never answer battle warnings, so they always stand and fight.
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, b.key(routing.BattleWarningsPrefix+"."+username), b.key(routing.BattleWarningsPrefix+"."+username), int(amqp.Transient), pubsub.HandlerBattleWarning(gs), pubsub.UnmarshallerUnsealed(signer, b.key(routing.BattleWarningsPrefix+"."+username), pubsub.UnmarshallerBattleWarning()))
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, b.key(routing.GameOverKey+"."+username), b.key(routing.GameOverKey), int(amqp.Transient), pubsub.HandlerGameOver(gs), pubsub.UnmarshallerGameOver())
+	if err != nil {
+		return err
+	}
+
+	// Lobby requests are only verified once the server knows our key, so
+	// joining waits for the registry to include it.
+	registered := make(chan struct{}, 1)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, routing.KeyRegistryKey+"."+username, routing.KeyRegistryKey, int(amqp.Transient), pubsub.HandlerKeyRegistered(registry, username, registered), pubsub.UnmarshallerKeyRegistry())
+	if err != nil {
+		return err
+	}
+	replies := make(chan gamelogic.LobbyReply, lobbyReplies)
+	replyTo, err := pubsub.SubscribePrivateJSON(conn, pubsub.HandlerLobbyReply(replies), pubsub.UnmarshallerLobbyReply())
+	if err != nil {
+		return err
+	}
+	err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.KeyAnnouncementsPrefix+"."+username, signer.Announcement())
+	if err != nil {
+		return err
+	}
+	err = b.join(ch, registered, replyTo, replies)
+	if err != nil {
+		return err
+	}
+	err = b.announce(ch, gamelogic.PresenceJoin)
+	if err != nil {
+		return err
+	}
+	// Saying goodbye uses its own channel, in case ch is what failed.
+	defer func() {
+		leaveCh, err := conn.Channel()
+		if err == nil {
+			b.announce(leaveCh, gamelogic.PresenceLeave)
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go b.sendHeartbeats(conn, done)
+
+	// The ruleset may still be on the way when the bot joins, and the turn
+	// number is only known from a turn result, so the bot sits out the turn
+	// it joined on and plays every turn after that.
+	for tr := range turns {
+		if tr.GameOver != nil {
+			return nil
+		}
+		err := b.settle(settled, tr.Turn)
+		if err != nil {
+			fmt.Println(fmt.Errorf("%s: %v", b.username, err))
+			continue
+		}
+		b.play(ch, gs, tr.Moves)
+	}
+	return nil
+}
+
+// settle waits until the bot's army has been updated for the turn after
+// resolved, so it never plans with the army it had before the turn.
+func (b *bot) settle(settled <-chan int, resolved int) error {
+	timeout := time.After(settleTimeout)
+	for {
+		select {
+		case turn := <-settled:
+			if turn > resolved {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("no update to the army after turn %d, skipping it", resolved)
+		}
+	}
+}
+
+// join asks the lobby to let the bot into its game once its key is
+// registered.
+func (b *bot) join(ch *amqp.Channel, registered <-chan struct{}, replyTo string, replies <-chan gamelogic.LobbyReply) error {
+	timeout := time.After(joinTimeout)
+	select {
+	case <-registered:
+	case <-timeout:
+		return errors.New("the server did not register our key")
+	}
+	id, err := gamelogic.NewRequestID()
+	if err != nil {
+		return err
+	}
+	lr := gamelogic.LobbyRequest{
+		ID:       id,
+		ReplyTo:  replyTo,
+		Username: b.username,
+		Action:   gamelogic.LobbyJoin,
+		GameID:   b.gameID,
+	}
+	err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.LobbyPrefix+"."+b.username, lr)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case reply := <-replies:
+			if reply.RequestID != lr.ID {
+				continue
+			}
+			if reply.Error != "" {
+				return fmt.Errorf("could not join %s: %s", gamelogic.GameName(b.gameID), reply.Error)
+			}
+			return nil
+		case <-timeout:
+			return errors.New("the lobby did not answer")
+		}
+	}
+}
+
+// play asks the strategy for this turn's orders, publishes the ones the game
+// allows and then tells the server the bot is done.
+func (b *bot) play(ch *amqp.Channel, gs *gamelogic.GameState, observed []gamelogic.ArmyMove) {
+	spawns, moves := gs.CarryOut(b.strategy.Plan(gs, observed))
+	for _, so := range spawns {
+		err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, b.key(routing.SpawnOrdersPrefix+"."+b.username), so)
+		if err != nil {
+			fmt.Println(fmt.Errorf("%s: spawn failed: %v", b.username, err))
+		}
+	}
+	for _, am := range moves {
+		err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, b.key(routing.MoveOrdersPrefix+"."+b.username), am)
+		if err != nil {
+			fmt.Println(fmt.Errorf("%s: move failed: %v", b.username, err))
+		}
+	}
+	ts, err := gs.CommandDone()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, b.key(routing.TurnSubmissionsPrefix+"."+b.username), ts)
+	if err != nil {
+		fmt.Println(fmt.Errorf("%s: done failed: %v", b.username, err))
+	}
+}
+
+// announce tells the server the bot joined, is still there or is leaving.
+func (b *bot) announce(ch *amqp.Channel, kind string) error {
+	p := gamelogic.Presence{
+		Username: b.username,
+		Kind:     kind,
+		SentAt:   time.Now(),
+	}
+	return pubsub.PublishJSON(ch, routing.ExchangePerilTopic, b.key(routing.PresencePrefix+"."+b.username), p)
+}
+
+// sendHeartbeats tells the server the bot is still there until done is
+// closed. It has its own channel since amqp channels are not safe to share
+// with the turn loop.
+func (b *bot) sendHeartbeats(conn *amqp.Connection, done <-chan struct{}) {
+	ch, err := conn.Channel()
+	if err != nil {
+		fmt.Println(fmt.Errorf("could not open heartbeat channel for %s: %v", b.username, err))
+		return
+	}
+	defer ch.Close()
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := b.announce(ch, gamelogic.PresenceHeartbeat)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not send heartbeat for %s: %v", b.username, err))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// lobbyTimeout is how long to wait for the server to answer a lobby request.
+const lobbyTimeout = 5 * time.Second
+
+// lobbyReplies is how many lobby replies can wait to be read. Replies to
+// requests that already timed out are skipped by askLobby.
+const lobbyReplies = 8
+
+// replyQueue is the private queue the lobby answers on, and where its
+// handler passes the replies on.
+type replyQueue struct {
+	name    string
+	replies <-chan gamelogic.LobbyReply
+}
+
+// askLobby sends a request to the server's lobby and waits for the reply to
+// it, skipping any late replies to earlier requests.
+func askLobby(ch *amqp.Channel, rq replyQueue, lr gamelogic.LobbyRequest) (gamelogic.LobbyReply, error) {
+	id, err := gamelogic.NewRequestID()
+	if err != nil {
+		return gamelogic.LobbyReply{}, err
+	}
+	lr.ID = id
+	lr.ReplyTo = rq.name
+	err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.LobbyPrefix+"."+lr.Username, lr)
+	if err != nil {
+		return gamelogic.LobbyReply{}, err
+	}
+	timeout := time.After(lobbyTimeout)
+	for {
+		select {
+		case reply := <-rq.replies:
+			if reply.RequestID != lr.ID {
+				continue
+			}
+			return reply, nil
+		case <-timeout:
+			return gamelogic.LobbyReply{}, errors.New("the lobby did not answer, is the server running?")
+		}
+	}
+}
+
+// pickGame lists, creates and picks games until the player chooses one to
+// join, and returns its ID. Joining is left to the caller, so it can listen
+// to the game before the server starts talking about it.
+func pickGame(ch *amqp.Channel, rq replyQueue, username string) string {
+	gamelogic.PrintLobbyHelp()
+	for {
+		s := gamelogic.GetInput()
+		if len(s) == 0 {
+			continue
+		}
+		switch s[0] {
+		case "help":
+			gamelogic.PrintLobbyHelp()
+			continue
+		case "quit":
+			gamelogic.PrintQuit()
+			os.Exit(0)
+		}
+		lr, err := gamelogic.CommandLobby(username, s)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if lr.Action == gamelogic.LobbyJoin {
+			return lr.GameID
+		}
+		reply, err := askLobby(ch, rq, lr)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		gamelogic.PrintLobbyReply(reply)
+		if lr.Action == gamelogic.LobbyCreate && reply.Error == "" {
+			return lr.GameID
+		}
+	}
+}
+
+// joinGame lets the player into gameID. With a session from an earlier run
+// it resumes instead, catching up on what was missed; if the server no longer
+// knows the session the player simply joins again.
+func joinGame(ch *amqp.Channel, rq replyQueue, gs *gamelogic.GameState, gameID string, session *gamelogic.Session) error {
+	username := gs.GetUsername()
+	if session != nil {
+		reply, err := askLobby(ch, rq, gamelogic.LobbyRequest{
+			Username: username,
+			Action:   gamelogic.LobbyResume,
+			GameID:   gameID,
+			Token:    session.Token,
+			Turn:     gs.GetTurn(),
+		})
+		if err != nil {
+			return err
+		}
+		gamelogic.PrintLobbyReply(reply)
+		if reply.Error == "" {
+			return gs.Resume(*reply.Snapshot)
+		}
+		fmt.Println("Joining again instead.")
+	}
+
+	reply, err := askLobby(ch, rq, gamelogic.LobbyRequest{
+		Username: username,
+		Action:   gamelogic.LobbyJoin,
+		GameID:   gameID,
+	})
+	if err != nil {
+		return err
+	}
+	gamelogic.PrintLobbyReply(reply)
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return gamelogic.SaveSession(gamelogic.Session{
+		GameID:   gameID,
+		Username: username,
+		Token:    reply.Token,
+	})
+}
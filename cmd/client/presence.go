@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// announce tells the server that the player joined, is still there or is
+// leaving the game.
+func announce(ch *amqp.Channel, gameID, username, kind string) error {
+	p := gamelogic.Presence{
+		Username: username,
+		Kind:     kind,
+		SentAt:   time.Now(),
+	}
+	return pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.GameKey(gameID, routing.PresencePrefix+"."+username), p)
+}
+
+// sendHeartbeats tells the server the player is still there until the client
+// exits. It has its own channel since amqp channels are not safe to share
+// with the input loop.
+func sendHeartbeats(conn *amqp.Connection, gameID, username string) {
+	ch, err := conn.Channel()
+	if err != nil {
+		gamelogic.Exit(err, 1)
+	}
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := announce(ch, gameID, username, gamelogic.PresenceHeartbeat)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not send heartbeat: %v", err))
+		}
+	}
+}
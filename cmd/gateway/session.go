@@ -0,0 +1,482 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/gorilla/websocket"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	frameLogin     = "login"
+	frameWelcome   = "welcome"
+	frameSpawn     = "spawn"
+	frameMove      = "move"
+	framePromote   = "promote"
+	frameStatus    = "status"
+	framePause     = "pause"
+	frameDelta     = "delta"
+	frameRuleset   = "ruleset"
+	frameWhisper   = "whisper"
+	frameDone      = "done"
+	frameTreasury  = "treasury"
+	frameTurn      = "turn"
+	frameGameOver  = "game_over"
+	frameDiplomacy = "diplomacy"
+	frameTreaty    = "treaty"
+	frameBattle    = "battle"
+	frameGames     = "games"
+	frameLeave     = "leave"
+	frameLobby     = "lobby"
+	frameRoster    = "roster"
+	frameError     = "error"
+)
+
+// frame is the JSON envelope exchanged with browsers. Requests fill in the
+// command fields, events carry their payload in Data. Username is only read
+// when logging in; commands aimed at another player name them in To.
+type frame struct {
+	Type     string `json:"type"`
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Game     string `json:"game,omitempty"`
+	Session  string `json:"session,omitempty"`
+	Location string `json:"location,omitempty"`
+	Rank     string `json:"rank,omitempty"`
+	Units    []int  `json:"units,omitempty"`
+	To       string `json:"to,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Data     any    `json:"data,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// joinTimeout is how long a session waits to be registered with the server
+// and let into its game.
+const joinTimeout = 5 * time.Second
+
+type session struct {
+	ws          *websocket.Conn
+	gameID      string
+	resumeToken string
+	// replyTo is the private queue the lobby answers the session on.
+	replyTo string
+	mu      *sync.Mutex
+}
+
+func newSession(ws *websocket.Conn) *session {
+	return &session{
+		ws: ws,
+		mu: &sync.Mutex{},
+	}
+}
+
+// key namespaces a routing key or queue name to the session's game.
+func (s *session) key(k string) string {
+	return routing.GameKey(s.gameID, k)
+}
+
+func (s *session) send(f frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.ws.WriteJSON(f)
+	if err != nil {
+		fmt.Println(fmt.Errorf("could not write %s frame: %v", f.Type, err))
+	}
+}
+
+func (s *session) sendError(err error) {
+	s.send(frame{Type: frameError, Error: err.Error()})
+}
+
+// run bridges the socket to the broker until the browser disconnects. Each
+// player gets their own connection so the transient queues are torn down with
+// it.
+func (s *session) run(amqpURL string, registry *identity.Registry, username string) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	signer, err := identity.LoadOrCreateSigner(username)
+	if err != nil {
+		return err
+	}
+	pubsub.AddSigner(signer)
+	defer pubsub.RemoveSigner(username)
+
+	gs := gamelogic.NewGameState(username)
+
+	hp := pubsub.HandlerPause(gs)
+	hsd := pubsub.HandlerStateDelta(gs)
+	hr := pubsub.HandlerRuleset(gs)
+	htr := pubsub.HandlerTurnResult(gs)
+	hgo := pubsub.HandlerGameOver(gs)
+	htu := pubsub.HandlerTreatyUpdate(gs)
+	hbw := pubsub.HandlerBattleWarning(gs)
+
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, s.key(routing.PauseKey+"."+username), s.key(routing.PauseKey), int(amqp.Transient), func(ps routing.PlayingState, aCh *amqp.Channel) int {
+		s.send(frame{Type: framePause, Data: ps})
+		return hp(ps, aCh)
+	}, pubsub.UnmarshallerPlayingState())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, s.key(routing.RulesetKey+"."+username), s.key(routing.RulesetKey), int(amqp.Transient), func(r gamelogic.Ruleset, aCh *amqp.Channel) int {
+		ack := hr(r, aCh)
+		if ack == pubsub.Ack {
+			s.send(frame{Type: frameRuleset, Data: r})
+		}
+		return ack
+	}, pubsub.UnmarshallerRuleset())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, s.key(routing.TurnResultsPrefix+"."+username), s.key(routing.TurnResultsPrefix+"."+username), int(amqp.Transient), func(tr gamelogic.TurnResult, aCh *amqp.Channel) int {
+		ack := htr(tr, aCh)
+		s.send(frame{Type: frameTurn, Data: tr})
+		return ack
+	}, pubsub.UnmarshallerUnsealed(signer, s.key(routing.TurnResultsPrefix+"."+username), pubsub.UnmarshallerTurnResult()))
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, s.key(routing.TreatiesPrefix+"."+username), s.key(routing.TreatiesPrefix+"."+username), int(amqp.Persistent), func(tu gamelogic.TreatyUpdate, aCh *amqp.Channel) int {
+		ack := htu(tu, aCh)
+		s.send(frame{Type: frameTreaty, Data: tu.Treaty})
+		return ack
+	}, pubsub.UnmarshallerTreatyUpdate())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, s.key(routing.BattleWarningsPrefix+"."+username), s.key(routing.BattleWarningsPrefix+"."+username), int(amqp.Transient), func(bw gamelogic.BattleWarning, aCh *amqp.Channel) int {
+		ack := hbw(bw, aCh)
+		s.send(frame{Type: frameBattle, Data: bw})
+		return ack
+	}, pubsub.UnmarshallerUnsealed(signer, s.key(routing.BattleWarningsPrefix+"."+username), pubsub.UnmarshallerBattleWarning()))
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, s.key(routing.GameOverKey+"."+username), s.key(routing.GameOverKey), int(amqp.Transient), func(g gamelogic.GameOver, aCh *amqp.Channel) int {
+		ack := hgo(g, aCh)
+		s.send(frame{Type: frameGameOver, Data: g})
+		return ack
+	}, pubsub.UnmarshallerGameOver())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, s.key(routing.RosterKey+"."+username), s.key(routing.RosterKey), int(amqp.Transient), func(pp gamelogic.PlayerPresence, _ *amqp.Channel) int {
+		s.send(frame{Type: frameRoster, Data: pp})
+		return pubsub.Ack
+	}, pubsub.UnmarshallerPlayerPresence())
+	if err != nil {
+		return err
+	}
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, s.key(routing.StateDeltasPrefix+"."+username), s.key(routing.StateDeltasPrefix+"."+username), int(amqp.Transient), func(sd gamelogic.StateDelta, aCh *amqp.Channel) int {
+		ack := hsd(sd, aCh)
+		s.send(frame{Type: frameDelta, Data: sd})
+		s.send(frame{Type: frameStatus, Data: gs.GetPlayerSnap()})
+		return ack
+	}, pubsub.UnmarshallerUnsealed(signer, s.key(routing.StateDeltasPrefix+"."+username), pubsub.UnmarshallerStateDelta()))
+	if err != nil {
+		return err
+	}
+
+	// Lobby requests are only verified once the server knows our key, so
+	// joining waits for the registry to include it.
+	registered := make(chan struct{}, 1)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilDirect, routing.KeyRegistryKey+"."+username, routing.KeyRegistryKey, int(amqp.Transient), pubsub.HandlerKeyRegistered(registry, username, registered), pubsub.UnmarshallerKeyRegistry())
+	if err != nil {
+		return err
+	}
+	joined := make(chan gamelogic.LobbyReply, 1)
+	s.replyTo, err = pubsub.SubscribePrivateJSON(conn, func(reply gamelogic.LobbyReply, _ *amqp.Channel) int {
+		s.send(frame{Type: frameLobby, Data: reply})
+		if reply.Action == gamelogic.LobbyJoin || reply.Action == gamelogic.LobbyResume {
+			select {
+			case joined <- reply:
+			default:
+			}
+		}
+		return pubsub.Ack
+	}, pubsub.UnmarshallerLobbyReply())
+	if err != nil {
+		return err
+	}
+	hwh := pubsub.HandlerWhisper(gs, signer)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, routing.DiplomacyPrefix+"."+username, routing.DiplomacyPrefix+"."+username, int(amqp.Persistent), func(w routing.Whisper, aCh *amqp.Channel) int {
+		ack := hwh(w, aCh)
+		if ack == pubsub.Ack {
+			inbox := gs.GetInboxSnap()
+			s.send(frame{Type: frameWhisper, Data: inbox[len(inbox)-1]})
+		}
+		return ack
+	}, pubsub.UnmarshallerWhisper())
+	if err != nil {
+		return err
+	}
+	err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.KeyAnnouncementsPrefix+"."+username, signer.Announcement())
+	if err != nil {
+		return err
+	}
+	err = s.join(ch, gs, registered, joined)
+	if err != nil {
+		return err
+	}
+	err = s.announce(ch, username, gamelogic.PresenceJoin)
+	if err != nil {
+		return err
+	}
+	// Saying goodbye uses its own channel, in case ch is what failed.
+	defer func() {
+		leaveCh, err := conn.Channel()
+		if err == nil {
+			s.announce(leaveCh, username, gamelogic.PresenceLeave)
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go s.sendHeartbeats(conn, username, done)
+
+	s.send(frame{Type: frameWelcome, Username: username, Data: gs.GetPlayerSnap()})
+
+	for {
+		var f frame
+		err := s.ws.ReadJSON(&f)
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		err = s.handleFrame(gs, ch, registry, f)
+		if err != nil {
+			s.sendError(err)
+		}
+	}
+}
+
+// join asks the lobby to let the player into the session's game once their
+// key is registered, or to resume their session if the browser gave a token.
+// The token to resume with next time is in the lobby frame.
+func (s *session) join(ch *amqp.Channel, gs *gamelogic.GameState, registered <-chan struct{}, joined <-chan gamelogic.LobbyReply) error {
+	timeout := time.After(joinTimeout)
+	select {
+	case <-registered:
+	case <-timeout:
+		return errors.New("the server did not register our key")
+	}
+	id, err := gamelogic.NewRequestID()
+	if err != nil {
+		return err
+	}
+	lr := gamelogic.LobbyRequest{
+		ID:       id,
+		Username: gs.GetUsername(),
+		Action:   gamelogic.LobbyJoin,
+		GameID:   s.gameID,
+	}
+	if s.resumeToken != "" {
+		lr.Action = gamelogic.LobbyResume
+		lr.Token = s.resumeToken
+		lr.Turn = gs.GetTurn()
+	}
+	err = s.publishLobby(ch, lr)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case reply := <-joined:
+			if reply.RequestID != lr.ID {
+				continue
+			}
+			if reply.Error != "" {
+				return fmt.Errorf("could not %s %s: %s", lr.Action, gamelogic.GameName(s.gameID), reply.Error)
+			}
+			if reply.Snapshot != nil {
+				return gs.Resume(*reply.Snapshot)
+			}
+			return nil
+		case <-timeout:
+			return errors.New("the lobby did not answer")
+		}
+	}
+}
+
+// askLobby sends a lobby request about the session's game. The reply is
+// passed on to the browser as a lobby frame, carrying the request's ID.
+func (s *session) askLobby(ch *amqp.Channel, username, action string) error {
+	id, err := gamelogic.NewRequestID()
+	if err != nil {
+		return err
+	}
+	return s.publishLobby(ch, gamelogic.LobbyRequest{
+		ID:       id,
+		Username: username,
+		Action:   action,
+		GameID:   s.gameID,
+	})
+}
+
+func (s *session) publishLobby(ch *amqp.Channel, lr gamelogic.LobbyRequest) error {
+	lr.ReplyTo = s.replyTo
+	return pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.LobbyPrefix+"."+lr.Username, lr)
+}
+
+// announce tells the server the player joined, is still there or is leaving.
+func (s *session) announce(ch *amqp.Channel, username, kind string) error {
+	p := gamelogic.Presence{
+		Username: username,
+		Kind:     kind,
+		SentAt:   time.Now(),
+	}
+	return pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.PresencePrefix+"."+username), p)
+}
+
+// sendHeartbeats tells the server the player is still there until done is
+// closed. It has its own channel since amqp channels are not safe to share
+// with the read loop.
+func (s *session) sendHeartbeats(conn *amqp.Connection, username string, done <-chan struct{}) {
+	ch, err := conn.Channel()
+	if err != nil {
+		fmt.Println(fmt.Errorf("could not open heartbeat channel for %s: %v", username, err))
+		return
+	}
+	defer ch.Close()
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := s.announce(ch, username, gamelogic.PresenceHeartbeat)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not send heartbeat for %s: %v", username, err))
+		}
+	}
+}
+
+// handleFrame turns a browser request into the same command words the CLI
+// client feeds to gamelogic.
+func (s *session) handleFrame(gs *gamelogic.GameState, ch *amqp.Channel, registry *identity.Registry, f frame) error {
+	switch f.Type {
+	case frameSpawn:
+		unit, err := gs.CommandSpawn([]string{frameSpawn, f.Location, f.Rank})
+		if err != nil {
+			return err
+		}
+		so := gamelogic.SpawnOrder{
+			Username: gs.GetUsername(),
+			Unit:     unit,
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.SpawnOrdersPrefix+"."+gs.GetUsername()), so)
+		if err != nil {
+			return fmt.Errorf("spawn failed: %v", err)
+		}
+	case framePromote:
+		if len(f.Units) != 1 {
+			return errors.New("promote needs exactly one unit")
+		}
+		po, err := gs.CommandPromote([]string{framePromote, strconv.Itoa(f.Units[0])})
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.PromotionOrdersPrefix+"."+gs.GetUsername()), po)
+		if err != nil {
+			return fmt.Errorf("promote failed: %v", err)
+		}
+	case frameMove:
+		words := []string{frameMove, f.Location}
+		for _, id := range f.Units {
+			words = append(words, strconv.Itoa(id))
+		}
+		m, err := gs.CommandMove(words)
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.MoveOrdersPrefix+"."+gs.GetUsername()), m)
+		if err != nil {
+			return fmt.Errorf("move failed: %v", err)
+		}
+	case frameDone:
+		ts, err := gs.CommandDone()
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.TurnSubmissionsPrefix+"."+gs.GetUsername()), ts)
+		if err != nil {
+			return fmt.Errorf("done failed: %v", err)
+		}
+	case frameWhisper:
+		to, text, err := gs.CommandWhisper([]string{frameWhisper, f.To, f.Text})
+		if err != nil {
+			return err
+		}
+		w, err := registry.Seal(gs.GetUsername(), to, text)
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.DiplomacyPrefix+"."+to, w)
+		if err != nil {
+			return fmt.Errorf("whisper failed: %v", err)
+		}
+		return nil
+	case gamelogic.DiplomacyAlly, gamelogic.DiplomacyTruce, gamelogic.DiplomacyBreak:
+		da, err := gs.CommandDiplomacy([]string{f.Type, f.To})
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.DiplomaticActionsPrefix+"."+gs.GetUsername()), da)
+		if err != nil {
+			return fmt.Errorf("%s failed: %v", f.Type, err)
+		}
+		return nil
+	case gamelogic.ChoiceFight, gamelogic.ChoiceRetreat, gamelogic.ChoiceSurrender:
+		words := []string{f.Type, f.Location}
+		if f.Type == gamelogic.ChoiceRetreat {
+			words = append(words, f.To)
+		}
+		for _, id := range f.Units {
+			words = append(words, strconv.Itoa(id))
+		}
+		bc, err := gs.CommandBattleChoice(words)
+		if err != nil {
+			return err
+		}
+		err = pubsub.PublishJSON(ch, routing.ExchangePerilTopic, s.key(routing.BattleChoicesPrefix+"."+gs.GetUsername()), bc)
+		if err != nil {
+			return fmt.Errorf("%s failed: %v", f.Type, err)
+		}
+		return nil
+	case frameDiplomacy:
+		s.send(frame{Type: frameDiplomacy, Data: gs.GetTreatiesSnap()})
+		return nil
+	case frameGames:
+		return s.askLobby(ch, gs.GetUsername(), gamelogic.LobbyList)
+	case frameLeave:
+		return s.askLobby(ch, gs.GetUsername(), gamelogic.LobbyLeave)
+	case frameTreasury:
+		s.send(frame{Type: frameTreasury, Data: gs.GetTreasury()})
+		return nil
+	case frameStatus:
+	default:
+		return fmt.Errorf("unknown frame type: %q", f.Type)
+	}
+	s.send(frame{Type: frameStatus, Data: gs.GetPlayerSnap()})
+	return nil
+}
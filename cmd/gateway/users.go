@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// users is who may log in through the gateway, with the SHA-256 of each
+// one's token. Only hashes are kept, so the file does not give the tokens
+// away.
+type users map[string][sha256.Size]byte
+
+// loadUsers reads a users file. Each line is a username and the hex SHA-256
+// of their token, such as printf %s "$TOKEN" | sha256sum prints. Blank lines
+// and lines starting with # are skipped.
+func loadUsers(path string) (users, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open users file: %v", err)
+	}
+	defer f.Close()
+
+	u := users{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || !validUsername(fields[0]) {
+			return nil, fmt.Errorf("%s:%d: expected a username and a token hash", path, line)
+		}
+		sum, err := hex.DecodeString(fields[1])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: the token hash is not a hex SHA-256", path, line)
+		}
+		u[fields[0]] = [sha256.Size]byte(sum)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("could not read users file: %v", err)
+	}
+	if len(u) == 0 {
+		return nil, fmt.Errorf("%s lists no users", path)
+	}
+	return u, nil
+}
+
+// check reports whether token is username's. Unknown users are compared
+// against an empty hash, so how long it takes does not tell them apart.
+func (u users) check(username, token string) bool {
+	want, ok := u[username]
+	got := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok
+}
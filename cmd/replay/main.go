@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// replay rebuilds a player's game from their client's journal, one event at a
+// time.
+func main() {
+	username := flag.String("user", "", "player whose journal to replay")
+	step := flag.Bool("step", false, "wait for enter after every event")
+	until := flag.Int("until", 0, "stop after this event (0 replays everything)")
+	flag.Parse()
+	if *username == "" {
+		gamelogic.Exit(fmt.Errorf("usage: replay -user <username> [-step] [-until <seq>]"), 2)
+	}
+
+	events, err := gamelogic.ReadJournal(*username)
+	if err != nil {
+		gamelogic.Exit(err, 1)
+	}
+	if *until > 0 {
+		for i, e := range events {
+			if e.Seq > *until {
+				events = events[:i]
+				break
+			}
+		}
+	}
+	fmt.Printf("Replaying %d event(s) for %s\n", len(events), *username)
+
+	stdin := bufio.NewScanner(os.Stdin)
+	gs := gamelogic.Replay(*username, events, func(e gamelogic.Event, gs *gamelogic.GameState) {
+		p := gs.GetPlayerSnap()
+		fmt.Printf("#%d %s turn %d: %s (%d unit(s), %d in the treasury)\n", e.Seq, e.At.Format(time.TimeOnly), gs.GetTurn(), e.Describe(), len(p.Units), gs.GetTreasury())
+		if *step && !stdin.Scan() {
+			os.Exit(0)
+		}
+	})
+
+	fmt.Println("==== Final State ====")
+	gs.CommandStatus()
+}
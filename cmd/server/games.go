@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// host runs every game the server hosts.
+type host struct {
+	conn       *amqp.Connection
+	turnLength time.Duration
+	window     time.Duration
+	spectators bool
+	games      map[string]*hostedGame
+	mu         *sync.Mutex
+}
+
+// hostedGame is a game being played, along with the clock that drives it and
+// who is connected to it. Closing done stops the game's goroutines. idleSince
+// is when the game was last found over or with nobody connected, and is zero
+// while it is being played.
+type hostedGame struct {
+	world     *gamelogic.World
+	clock     *turnClock
+	roster    *gamelogic.Roster
+	done      chan struct{}
+	idleSince time.Time
+}
+
+// idleTimeout is how long a created game can be over, or have nobody connected
+// to it, before it is closed. Players who drop out for less can still resume.
+const idleTimeout = 10 * time.Minute
+
+// gameQueues are the prefixes of every queue start declares for a game, so
+// they can be deleted when it is closed.
+var gameQueues = []string{
+	routing.SpawnOrdersPrefix,
+	routing.MoveOrdersPrefix,
+	routing.PromotionOrdersPrefix,
+	routing.DiplomaticActionsPrefix,
+	routing.BattleChoicesPrefix,
+	routing.TurnSubmissionsPrefix,
+	routing.GameLogSlug,
+	routing.PresencePrefix,
+}
+
+func newHost(conn *amqp.Connection, turnLength, window time.Duration, spectators bool) *host {
+	return &host{
+		conn:       conn,
+		turnLength: turnLength,
+		window:     window,
+		spectators: spectators,
+		games:      map[string]*hostedGame{},
+		mu:         &sync.Mutex{},
+	}
+}
+
+// game returns the hosted game called id.
+func (h *host) game(id string) (*hostedGame, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	g, ok := h.games[id]
+	return g, ok
+}
+
+// start subscribes to the orders for world's game and starts the clock that
+// resolves its turns. Every game has its own queues, so one game's orders
+// never reach another. If a subscription fails, the queues already declared
+// are deleted again.
+func (h *host) start(world *gamelogic.World) error {
+	conn := h.conn
+	done := make(chan struct{})
+	clock := newTurnClock(conn, world, h.turnLength, h.window, done)
+	clock.spectators = h.spectators
+	roster := gamelogic.NewRoster()
+	key := func(prefix string) (string, string) {
+		return routing.GameKey(world.ID, prefix), routing.GameKey(world.ID, prefix+".*")
+	}
+
+	queue, pattern := key(routing.SpawnOrdersPrefix)
+	err := pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerSpawnOrder(world), pubsub.UnmarshallerSpawnOrder())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+	queue, pattern = key(routing.MoveOrdersPrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerMoveOrder(world), pubsub.UnmarshallerMove())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+	queue, pattern = key(routing.PromotionOrdersPrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerPromotionOrder(world), pubsub.UnmarshallerPromotionOrder())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+	queue, pattern = key(routing.DiplomaticActionsPrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerDiplomaticAction(world), pubsub.UnmarshallerDiplomaticAction())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+	queue, pattern = key(routing.BattleChoicesPrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerBattleChoice(world, clock.responded), pubsub.UnmarshallerBattleChoice())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+	queue, pattern = key(routing.TurnSubmissionsPrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerTurnSubmission(world, clock.early), pubsub.UnmarshallerTurnSubmission())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+
+	queue, pattern = key(routing.GameLogSlug)
+	err = pubsub.SubscribeGob(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerGameLog(world), pubsub.UnmarshallerGameLog())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+
+	queue, pattern = key(routing.PresencePrefix)
+	err = pubsub.SubscribeJSON(conn, routing.ExchangePerilTopic, queue, pattern, int(amqp.Persistent), pubsub.HandlerPresence(world, roster), pubsub.UnmarshallerPresence())
+	if err != nil {
+		return h.abandon(world.ID, err)
+	}
+
+	g := &hostedGame{
+		world:  world,
+		clock:  clock,
+		roster: roster,
+		done:   done,
+	}
+	h.mu.Lock()
+	h.games[world.ID] = g
+	h.mu.Unlock()
+
+	go clock.run()
+	go g.watchPresence(conn)
+	return nil
+}
+
+// abandon deletes the queues of a game that failed to start, which ends the
+// subscriptions it did make, and returns err along with anything that went
+// wrong on the way.
+func (h *host) abandon(id string, err error) error {
+	return errors.Join(err, deleteQueues(h.conn, id))
+}
+
+// watchPresence drops players who stop sending heartbeats and tells everyone
+// else in the game they are gone.
+func (g *hostedGame) watchPresence(conn *amqp.Connection) {
+	ch, err := conn.Channel()
+	if err != nil {
+		gamelogic.Exit(err, 1)
+	}
+	defer ch.Close()
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-g.done:
+			return
+		}
+		for _, pp := range g.roster.Sweep(now) {
+			fmt.Printf("%s: %s dropped, last seen %v\n", gamelogic.GameName(g.world.ID), pp.Username, pp.LastSeen.Format(time.TimeOnly))
+			err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.GameKey(g.world.ID, routing.RosterKey), pp)
+			if err != nil {
+				fmt.Println(fmt.Errorf("could not publish roster: %v", err))
+			}
+		}
+	}
+}
+
+// idle reports whether nobody is playing g any more: it is over, or every
+// player has left or dropped out.
+func (g *hostedGame) idle() bool {
+	if g.world.IsOver() {
+		return true
+	}
+	for _, pp := range g.roster.Snapshot() {
+		if pp.Status == gamelogic.StatusConnected {
+			return false
+		}
+	}
+	return true
+}
+
+// reap closes every created game that has been idle for idleTimeout, so
+// finished and abandoned games do not pile up. The default game is always
+// kept.
+func (h *host) reap(lobby *gamelogic.Lobby) {
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.mu.Lock()
+		closing := []*hostedGame{}
+		for id, g := range h.games {
+			if id == "" {
+				continue
+			}
+			if !g.idle() {
+				g.idleSince = time.Time{}
+				continue
+			}
+			if g.idleSince.IsZero() {
+				g.idleSince = now
+			}
+			if now.Sub(g.idleSince) >= idleTimeout {
+				delete(h.games, id)
+				closing = append(closing, g)
+			}
+		}
+		h.mu.Unlock()
+		for _, g := range closing {
+			err := g.shutdown(h.conn, lobby)
+			if err != nil {
+				fmt.Println(fmt.Errorf("could not close %s: %v", gamelogic.GameName(g.world.ID), err))
+				continue
+			}
+			fmt.Printf("closed %s\n", gamelogic.GameName(g.world.ID))
+		}
+	}
+}
+
+// shutdown takes g out of the lobby, stops its clock and deletes its queues,
+// which ends their subscriptions. It carries on past every failure and
+// returns them all.
+func (g *hostedGame) shutdown(conn *amqp.Connection, lobby *gamelogic.Lobby) error {
+	err := lobby.Remove(g.world.ID)
+	close(g.done)
+	return errors.Join(err, deleteQueues(conn, g.world.ID))
+}
+
+// deleteQueues deletes every queue of the game called id on a channel of its
+// own. RabbitMQ closes a channel when a delete fails, so the rest are tried
+// on a new one. Deleting a queue that was never declared is not an error.
+func deleteQueues(conn *amqp.Connection, id string) error {
+	errs := []error{}
+	var ch *amqp.Channel
+	for _, prefix := range gameQueues {
+		if ch == nil || ch.IsClosed() {
+			var err error
+			ch, err = conn.Channel()
+			if err != nil {
+				return errors.Join(append(errs, err)...)
+			}
+			defer ch.Close()
+		}
+		queue := routing.GameKey(id, prefix)
+		_, err := ch.QueueDelete(queue, false, false, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete %s: %v", queue, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishPause tells world's players whether it is paused.
+func publishPause(ch *amqp.Channel, world *gamelogic.World) error {
+	return pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.GameKey(world.ID, routing.PauseKey), routing.PlayingState{IsPaused: world.IsPaused()})
+}
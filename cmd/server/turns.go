@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// turnClock drives the world from turn to turn. early is signalled when every
+// player has submitted, and responded when every defender has answered their
+// battle warning. With spectators set, each resolved turn is also published
+// in full for anyone watching. The clock stops when done is closed.
+type turnClock struct {
+	conn       *amqp.Connection
+	world      *gamelogic.World
+	turnLength time.Duration
+	window     time.Duration
+	spectators bool
+	early      chan struct{}
+	responded  chan struct{}
+	done       <-chan struct{}
+}
+
+func newTurnClock(conn *amqp.Connection, world *gamelogic.World, turnLength, window time.Duration, done <-chan struct{}) *turnClock {
+	return &turnClock{
+		conn:       conn,
+		world:      world,
+		turnLength: turnLength,
+		window:     window,
+		early:      make(chan struct{}, 1),
+		responded:  make(chan struct{}, 1),
+		done:       done,
+	}
+}
+
+// run resolves a turn every time the clock ticks, or as soon as every player
+// has submitted, until the game is over or closed. It has its own channel
+// since amqp channels are not safe to share with the input loop.
+func (tc *turnClock) run() {
+	ch, err := tc.conn.Channel()
+	if err != nil {
+		gamelogic.Exit(err, 1)
+	}
+	defer func() {
+		ch.Close()
+	}()
+	ticker := time.NewTicker(tc.turnLength)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-tc.early:
+			ticker.Reset(tc.turnLength)
+		case <-tc.done:
+			return
+		}
+		if tc.world.IsPaused() {
+			continue
+		}
+		// A failed publish closes the channel, and the next turn still has
+		// to go out.
+		ch, err = tc.reopen(ch)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not open a channel: %v", err))
+			continue
+		}
+		err = tc.resolveTurn(ch)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not resolve turn: %v", err))
+		}
+		if tc.world.IsOver() {
+			return
+		}
+	}
+}
+
+// reopen returns ch, or a new channel if ch has been closed.
+func (tc *turnClock) reopen(ch *amqp.Channel) (*amqp.Channel, error) {
+	if !ch.IsClosed() {
+		return ch, nil
+	}
+	return tc.conn.Channel()
+}
+
+// negotiate warns every defender of the battle coming their way and gives
+// them until the window closes to fight, retreat or surrender.
+func (tc *turnClock) negotiate(ch *amqp.Channel, warnings []gamelogic.BattleWarning) error {
+	if len(warnings) == 0 {
+		return nil
+	}
+	select {
+	case <-tc.responded:
+	default:
+	}
+	deadline := time.Now().Add(tc.window)
+	for _, bw := range warnings {
+		bw.Deadline = deadline
+		err := pubsub.PublishSealedJSON(ch, routing.ExchangePerilTopic, routing.GameKey(tc.world.ID, routing.BattleWarningsPrefix+"."+bw.Defender), bw.Defender, bw)
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case <-time.After(tc.window):
+	case <-tc.responded:
+	}
+	return nil
+}
+
+// syncPlayers tells every client about a game that was just loaded: its
+// ruleset, whether it is paused and what their army now is.
+func syncPlayers(ch *amqp.Channel, world *gamelogic.World) error {
+	err := pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.GameKey(world.ID, routing.RulesetKey), *world.Rules)
+	if err != nil {
+		return err
+	}
+	err = publishPause(ch, world)
+	if err != nil {
+		return err
+	}
+	for _, username := range world.Viewers() {
+		err := pubsub.PublishSealedJSON(ch, routing.ExchangePerilTopic, routing.GameKey(world.ID, routing.StateDeltasPrefix+"."+username), username, world.Sync(username))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishSpectated publishes everything that happened in tr, unfiltered, to
+// the game's spectators: each move on army_moves, each war on war and then a
+// summary of where the game stands.
+func publishSpectated(ch *amqp.Channel, world *gamelogic.World, tr gamelogic.TurnResult) error {
+	for _, am := range tr.Moves {
+		err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.GameKey(world.ID, routing.ArmyMovesPrefix+"."+am.Player.Username), am)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ws := range tr.Wars {
+		err := pubsub.PublishJSON(ch, routing.ExchangePerilTopic, routing.GameKey(world.ID, routing.WarRecognitionsPrefix+"."+ws.Attacker), ws)
+		if err != nil {
+			return err
+		}
+	}
+	return pubsub.PublishJSON(ch, routing.ExchangePerilDirect, routing.GameKey(world.ID, routing.WorldSummaryKey), world.Summary())
+}
+
+func (tc *turnClock) resolveTurn(ch *amqp.Channel) error {
+	world := tc.world
+	err := tc.negotiate(ch, world.BeginTurn())
+	if err != nil {
+		// The turn still has to finish, so defenders who never heard of
+		// their battle simply fight.
+		fmt.Println(fmt.Errorf("could not publish battle warnings: %v", err))
+	}
+	tr, deltas, err := world.FinishTurn()
+	if err != nil {
+		return err
+	}
+	// A player who can not be sent their part of the turn must not keep
+	// everyone after them, or the end of the game, from being sent theirs.
+	for _, sd := range deltas {
+		err := pubsub.PublishSealedJSON(ch, routing.ExchangePerilTopic, routing.GameKey(world.ID, routing.StateDeltasPrefix+"."+sd.Username), sd.Username, sd)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not publish %s's delta: %v", sd.Username, err))
+		}
+	}
+	// Every player only gets to see what their own army can see. The views
+	// are kept for players who are not there to see them.
+	for _, viewer := range world.Viewers() {
+		view := world.ViewOf(tr, viewer)
+		world.KeepView(view)
+		err := pubsub.PublishSealedJSON(ch, routing.ExchangePerilTopic, routing.GameKey(world.ID, routing.TurnResultsPrefix+"."+viewer), viewer, view)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not publish %s's view: %v", viewer, err))
+		}
+	}
+
+	if tc.spectators {
+		err := publishSpectated(ch, world, tr)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not publish the turn for spectators: %v", err))
+		}
+	}
+
+	for _, ws := range tr.Wars {
+		message := ws.Message()
+		fmt.Println(message)
+		err := gamelogic.WriteLog(world.ID, routing.GameLog{
+			CurrentTime: time.Now(),
+			Message:     message,
+			Username:    routing.AuthorityUsername,
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	fmt.Printf("%s: resolved turn %d: %d move(s), %d battle(s) in %d war(s)\n", gamelogic.GameName(world.ID), tr.Turn, len(tr.Moves), len(tr.Battles), len(tr.Wars))
+
+	if tr.GameOver != nil {
+		fmt.Printf("%s: game over: %v %s\n", gamelogic.GameName(world.ID), tr.GameOver.Winners, tr.GameOver.Reason)
+		// The clock stops once the game is over, so this is the only chance
+		// to tell the players, even if an earlier publish broke ch.
+		gch, err := tc.reopen(ch)
+		if err == nil {
+			if gch != ch {
+				defer gch.Close()
+			}
+			err = pubsub.PublishJSON(gch, routing.ExchangePerilDirect, routing.GameKey(world.ID, routing.GameOverKey), *tr.GameOver)
+		}
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not publish game over: %v", err))
+		}
+		err = gamelogic.WriteLog(world.ID, routing.GameLog{
+			CurrentTime: time.Now(),
+			Message:     fmt.Sprintf("%v won the game: %s", tr.GameOver.Winners, tr.GameOver.Reason),
+			Username:    routing.AuthorityUsername,
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
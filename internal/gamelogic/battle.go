@@ -0,0 +1,195 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	ChoiceFight     = "fight"
+	ChoiceRetreat   = "retreat"
+	ChoiceSurrender = "surrender"
+)
+
+// BattleWarning tells a defender a battle is coming. Until Deadline they can
+// answer with a BattleChoice; after it they fight.
+type BattleWarning struct {
+	Turn          int
+	Location      Location
+	Attacker      string
+	Defender      string
+	AttackerUnits []Unit
+	Deadline      time.Time
+}
+
+func (bw BattleWarning) Claimant() string {
+	return bw.Defender
+}
+
+// BattleChoice is a defender's answer to a BattleWarning. A retreat moves every
+// unit in Location to the adjacent RetreatTo. A surrender gives up Units, or
+// every unit in Location when Units is empty.
+type BattleChoice struct {
+	Username  string
+	Turn      int
+	Location  Location
+	Choice    string
+	RetreatTo Location
+	Units     []int
+}
+
+func (bc BattleChoice) Claimant() string {
+	return bc.Username
+}
+
+func choiceKey(loc Location, defender string) string {
+	return string(loc) + "|" + defender
+}
+
+// RespondToBattle records a defender's choice for a battle announced by
+// BeginTurn. allResponded is true once every warned defender has answered.
+func (w *World) RespondToBattle(bc BattleChoice) (allResponded bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := w.resolving
+	if r == nil || bc.Turn != w.Turn {
+		return false, errors.New("there is no battle to respond to")
+	}
+	warned := slices.ContainsFunc(r.warnings, func(bw BattleWarning) bool {
+		return bw.Location == bc.Location && bw.Defender == bc.Username
+	})
+	if !warned {
+		return false, fmt.Errorf("you are not defending %s", bc.Location)
+	}
+
+	units := unitsInLocation(w.Players[bc.Username], bc.Location)
+	switch bc.Choice {
+	case ChoiceFight:
+	case ChoiceRetreat:
+		if !w.Map.Adjacent(bc.Location, bc.RetreatTo) {
+			return false, fmt.Errorf("you can only retreat from %s to an adjacent location", bc.Location)
+		}
+	case ChoiceSurrender:
+		for _, id := range bc.Units {
+			if !slices.ContainsFunc(units, func(u Unit) bool { return u.ID == id }) {
+				return false, fmt.Errorf("unit %v is not in %s", id, bc.Location)
+			}
+		}
+	default:
+		return false, fmt.Errorf("unknown battle choice: %q", bc.Choice)
+	}
+	r.choices[choiceKey(bc.Location, bc.Username)] = bc
+
+	for _, bw := range r.warnings {
+		if _, ok := r.choices[choiceKey(bw.Location, bw.Defender)]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// applyBattleChoices carries out every retreat and surrender before the
+// battles are fought. Defenders who did not answer fight.
+func (w *World) applyBattleChoices(r *resolution) {
+	keys := []string{}
+	for key := range r.choices {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		bc := r.choices[key]
+		p := w.Players[bc.Username]
+		units := unitsInLocation(p, bc.Location)
+		sortUnits(units)
+		sd := r.deltaFor(bc.Username)
+		switch bc.Choice {
+		case ChoiceRetreat:
+			for _, u := range units {
+				u.Location = bc.RetreatTo
+				p.Units[u.ID] = u
+				sd.Upserted = append(sd.Upserted, u)
+			}
+		case ChoiceSurrender:
+			ids := bc.Units
+			if len(ids) == 0 {
+				ids = unitIDs(units)
+			}
+			killUnits(p, ids)
+			sd.Removed = append(sd.Removed, ids...)
+		default:
+			continue
+		}
+		r.result.Choices = append(r.result.Choices, bc)
+	}
+}
+
+func (gs *GameState) GetBattleWarningsSnap() []BattleWarning {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]BattleWarning{}, gs.BattleWarnings...)
+}
+
+// HandleBattleWarning prompts you to decide what to do about a battle.
+func (gs *GameState) HandleBattleWarning(bw BattleWarning) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	gs.BattleWarnings = append(gs.BattleWarnings, bw)
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Battle Incoming ====")
+	fmt.Printf("%s is attacking %s with %d unit(s):\n", bw.Attacker, bw.Location, len(bw.AttackerUnits))
+	for _, u := range bw.AttackerUnits {
+		fmt.Printf("  * %v\n", u.Rank)
+	}
+	fmt.Printf("You have until %s to choose, otherwise you fight:\n", bw.Deadline.Format(time.TimeOnly))
+	fmt.Printf("* fight %s\n", bw.Location)
+	fmt.Printf("* retreat %s <adjacent location>\n", bw.Location)
+	fmt.Printf("* surrender %s [unitID...]\n", bw.Location)
+}
+
+// CommandBattleChoice parses "fight <location>", "retreat <location> <to>"
+// and "surrender <location> [unitID...]".
+func (gs *GameState) CommandBattleChoice(words []string) (BattleChoice, error) {
+	if len(words) < 2 {
+		return BattleChoice{}, fmt.Errorf("usage: %s <location>", words[0])
+	}
+	loc := Location(words[1])
+	i := slices.IndexFunc(gs.GetBattleWarningsSnap(), func(bw BattleWarning) bool {
+		return bw.Location == loc
+	})
+	if i < 0 {
+		return BattleChoice{}, fmt.Errorf("error: no battle is coming in %s", loc)
+	}
+	bc := BattleChoice{
+		Username: gs.GetUsername(),
+		Turn:     gs.GetBattleWarningsSnap()[i].Turn,
+		Location: loc,
+		Choice:   words[0],
+	}
+	switch words[0] {
+	case ChoiceFight:
+	case ChoiceRetreat:
+		if len(words) < 3 {
+			return BattleChoice{}, errors.New("usage: retreat <location> <to>")
+		}
+		bc.RetreatTo = Location(words[2])
+		if !gs.Map.Adjacent(loc, bc.RetreatTo) {
+			return BattleChoice{}, fmt.Errorf("error: %s is not adjacent to %s", bc.RetreatTo, loc)
+		}
+	case ChoiceSurrender:
+		for _, word := range words[2:] {
+			id, err := strconv.Atoi(word)
+			if err != nil {
+				return BattleChoice{}, fmt.Errorf("error: %s is not a valid unit ID", word)
+			}
+			bc.Units = append(bc.Units, id)
+		}
+	default:
+		return BattleChoice{}, fmt.Errorf("unknown battle choice: %q", words[0])
+	}
+	return bc, nil
+}
@@ -0,0 +1,255 @@
+package gamelogic
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+)
+
+const (
+	CombatPower = "power"
+	CombatDice  = "dice"
+)
+
+// DefaultDiceRounds is how many rounds of dice a battle lasts before the
+// survivors go home.
+const DefaultDiceRounds = 3
+
+// Battle is everything needed to settle a fight at one location. Units are
+// sorted by ID so every resolver sees them in the same order.
+type Battle struct {
+	Turn          int
+	Location      Location
+	Attacker      string
+	Defender      string
+	AttackerUnits []Unit
+	DefenderUnits []Unit
+}
+
+func NewBattle(turn int, loc Location, attacker, defender Player) Battle {
+	b := Battle{
+		Turn:          turn,
+		Location:      loc,
+		Attacker:      attacker.Username,
+		Defender:      defender.Username,
+		AttackerUnits: unitsInLocation(attacker, loc),
+		DefenderUnits: unitsInLocation(defender, loc),
+	}
+	sortUnits(b.AttackerUnits)
+	sortUnits(b.DefenderUnits)
+	return b
+}
+
+// CombatResolver decides who wins a battle and which units die. Resolvers
+// must be deterministic so the server and every client agree on the outcome.
+type CombatResolver interface {
+	Resolve(b Battle) BattleReport
+}
+
+// PowerResolver is the classic rule: the side with the higher total power
+// wins and every unit of the loser dies, though each of them wounds the
+// winners once on the way out. On a draw both sides are wiped out.
+type PowerResolver struct {
+	Rules *Ruleset
+}
+
+func (pr PowerResolver) Resolve(b Battle) BattleReport {
+	attackers := pr.Rules.upgradeUnits(b.AttackerUnits)
+	defenders := pr.Rules.upgradeUnits(b.DefenderUnits)
+	attackerPower := pr.Rules.PowerLevel(attackers)
+	defenderPower := pr.Rules.PowerLevel(defenders)
+	if attackerPower > defenderPower {
+		alive, dead := pr.Rules.wound(attackers, len(defenders))
+		return b.report(WarOutcomeYouWon, dead, unitIDs(defenders)).survive(pr.Rules, alive, nil)
+	} else if defenderPower > attackerPower {
+		alive, dead := pr.Rules.wound(defenders, len(attackers))
+		return b.report(WarOutcomeOpponentWon, unitIDs(attackers), dead).survive(pr.Rules, nil, alive)
+	}
+	return b.report(WarOutcomeDraw, unitIDs(attackers), unitIDs(defenders))
+}
+
+// DiceResolver fights Risk-style rounds. Each round the attacker's three and
+// the defender's two strongest units roll a die plus their power, highest
+// against highest, and the lower roll of each pair is wounded (the defender
+// wins ties). A unit dies when its health runs out. The side that lost fewer
+// units wins.
+type DiceResolver struct {
+	Rules  *Ruleset
+	Seed   int64
+	Rounds int
+}
+
+func (dr DiceResolver) Resolve(b Battle) BattleReport {
+	rng := rand.New(rand.NewSource(dr.battleSeed(b)))
+	attackers := dr.Rules.upgradeUnits(b.AttackerUnits)
+	defenders := dr.Rules.upgradeUnits(b.DefenderUnits)
+	attackerLosses := []int{}
+	defenderLosses := []int{}
+
+	for round := 0; round < dr.Rounds && len(attackers) > 0 && len(defenders) > 0; round++ {
+		// Wounds weaken units, so the strongest are picked again every round.
+		attackers = dr.byStrength(attackers)
+		defenders = dr.byStrength(defenders)
+		a := dr.roll(rng, attackers[:min(3, len(attackers))])
+		d := dr.roll(rng, defenders[:min(2, len(defenders))])
+		hitAttackers := map[int]bool{}
+		hitDefenders := map[int]bool{}
+		for i := 0; i < len(a) && i < len(d); i++ {
+			if a[i].score > d[i].score {
+				hitDefenders[d[i].unit.ID] = true
+			} else {
+				hitAttackers[a[i].unit.ID] = true
+			}
+		}
+		attackers, attackerLosses = hurt(attackers, hitAttackers, attackerLosses)
+		defenders, defenderLosses = hurt(defenders, hitDefenders, defenderLosses)
+	}
+	slices.Sort(attackerLosses)
+	slices.Sort(defenderLosses)
+	outcome := WarOutcomeDraw
+	if len(attackerLosses) < len(defenderLosses) {
+		outcome = WarOutcomeYouWon
+	} else if len(defenderLosses) < len(attackerLosses) {
+		outcome = WarOutcomeOpponentWon
+	}
+	return b.report(outcome, attackerLosses, defenderLosses).survive(dr.Rules, attackers, defenders)
+}
+
+// battleSeed mixes the game seed with what identifies the battle, so each one
+// can be replayed on its own regardless of the order battles are fought in.
+func (dr DiceResolver) battleSeed(b Battle) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, dr.Seed)
+	binary.Write(h, binary.BigEndian, int64(b.Turn))
+	h.Write([]byte(string(b.Location) + "\x00" + b.Attacker + "\x00" + b.Defender))
+	return int64(h.Sum64())
+}
+
+type dieRoll struct {
+	unit  Unit
+	score int
+}
+
+// roll gives each unit a die plus its power, highest score first.
+func (dr DiceResolver) roll(rng *rand.Rand, units []Unit) []dieRoll {
+	rolls := []dieRoll{}
+	for _, u := range units {
+		rolls = append(rolls, dieRoll{unit: u, score: rng.Intn(6) + 1 + dr.Rules.UnitPower(u)})
+	}
+	slices.SortStableFunc(rolls, func(a, b dieRoll) int {
+		return b.score - a.score
+	})
+	return rolls
+}
+
+// byStrength orders units by power, strongest first, then by ID.
+func (dr DiceResolver) byStrength(units []Unit) []Unit {
+	sorted := slices.Clone(units)
+	slices.SortStableFunc(sorted, func(a, b Unit) int {
+		pa := dr.Rules.UnitPower(a)
+		pb := dr.Rules.UnitPower(b)
+		if pa != pb {
+			return pb - pa
+		}
+		return a.ID - b.ID
+	})
+	return sorted
+}
+
+// hurt wounds every unit that was hit and removes those with no health left.
+func hurt(units []Unit, hit map[int]bool, losses []int) ([]Unit, []int) {
+	alive := []Unit{}
+	for _, u := range units {
+		if hit[u.ID] {
+			u.Health--
+		}
+		if u.Health <= 0 {
+			losses = append(losses, u.ID)
+			continue
+		}
+		alive = append(alive, u)
+	}
+	return alive, losses
+}
+
+// wound deals damage to units one point at a time, in order, so the first
+// units take the brunt of it. It returns the units left standing and the IDs
+// of those that died.
+func (r *Ruleset) wound(units []Unit, damage int) ([]Unit, []int) {
+	alive := []Unit{}
+	dead := []int{}
+	for _, u := range units {
+		hit := min(damage, u.Health)
+		u.Health -= hit
+		damage -= hit
+		if u.Health <= 0 {
+			dead = append(dead, u.ID)
+			continue
+		}
+		alive = append(alive, u)
+	}
+	return alive, dead
+}
+
+func (r *Ruleset) upgradeUnits(units []Unit) []Unit {
+	upgraded := []Unit{}
+	for _, u := range units {
+		upgraded = append(upgraded, r.UpgradeUnit(u))
+	}
+	return upgraded
+}
+
+// report turns the outcome of a battle, from the attacker's point of view,
+// into a BattleReport.
+func (b Battle) report(outcome WarOutcome, attackerLosses, defenderLosses []int) BattleReport {
+	br := BattleReport{
+		Location:       b.Location,
+		Attacker:       b.Attacker,
+		Defender:       b.Defender,
+		AttackerUnits:  b.AttackerUnits,
+		DefenderUnits:  b.DefenderUnits,
+		AttackerLosses: attackerLosses,
+		DefenderLosses: defenderLosses,
+		Outcome:        outcome,
+		Winner:         b.Attacker,
+		Loser:          b.Defender,
+	}
+	if outcome == WarOutcomeOpponentWon {
+		br.Winner, br.Loser = b.Defender, b.Attacker
+	}
+	return br
+}
+
+// survive records the units left standing on each side, each with the
+// experience the battle earned it.
+func (br BattleReport) survive(r *Ruleset, attackers, defenders []Unit) BattleReport {
+	br.AttackerSurvivors = r.gainXP(attackers)
+	br.DefenderSurvivors = r.gainXP(defenders)
+	return br
+}
+
+func (r *Ruleset) gainXP(units []Unit) []Unit {
+	veterans := []Unit{}
+	for _, u := range units {
+		u.XP += r.Veterancy.XPPerBattle
+		veterans = append(veterans, u)
+	}
+	sortUnits(veterans)
+	return veterans
+}
+
+func unitIDs(units []Unit) []int {
+	ids := []int{}
+	for _, u := range units {
+		ids = append(ids, u.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func sortUnits(units []Unit) {
+	slices.SortFunc(units, func(a, b Unit) int {
+		return a.ID - b.ID
+	})
+}
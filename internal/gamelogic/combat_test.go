@@ -0,0 +1,44 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testBattle is a fight big enough that the dice decide more than one unit.
+func testBattle() Battle {
+	r := DefaultRuleset()
+	b := Battle{
+		Turn:     4,
+		Location: "europe",
+		Attacker: "alice",
+		Defender: "bob",
+	}
+	for id := 1; id <= 6; id++ {
+		b.AttackerUnits = append(b.AttackerUnits, r.NewUnit(id, RankInfantry, b.Location))
+		b.DefenderUnits = append(b.DefenderUnits, r.NewUnit(id+100, RankInfantry, b.Location))
+	}
+	return b
+}
+
+func TestDiceResolverReplaysFromSeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		seed  int64
+		other int64
+		same  bool
+	}{
+		{name: "same seed", seed: 42, other: 42, same: true},
+		{name: "different seed", seed: 42, other: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBattle()
+			first := DiceResolver{Rules: DefaultRuleset(), Seed: tt.seed, Rounds: DefaultDiceRounds}.Resolve(b)
+			second := DiceResolver{Rules: DefaultRuleset(), Seed: tt.other, Rounds: DefaultDiceRounds}.Resolve(b)
+			if reflect.DeepEqual(first, second) != tt.same {
+				t.Errorf("seeds %d and %d gave\n%+v\nand\n%+v", tt.seed, tt.other, first, second)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package gamelogic
+
+import (
+	"fmt"
+)
+
+// HandleStateDelta brings the local army in line with the server's.
+func (gs *GameState) HandleStateDelta(sd StateDelta) {
+	if sd.Username != gs.GetUsername() {
+		return
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Server Update ====")
+	if sd.Rejected != "" {
+		fmt.Printf("The server rejected your order: %s\n", sd.Rejected)
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if sd.Snapshot != nil {
+		army := map[int]Unit{}
+		for k, v := range sd.Snapshot.Units {
+			army[k] = gs.Rules.UpgradeUnit(v)
+		}
+		gs.record(Event{Kind: EventArmyReset, Army: army})
+		fmt.Printf("Your army was reset to %d unit(s).\n", len(gs.Player.Units))
+	}
+	if sd.LastUnitID > gs.UnitIDs.Last {
+		gs.record(Event{Kind: EventUnitIDReserved, UnitIDs: []int{sd.LastUnitID}})
+	}
+	if sd.Treasury != gs.Treasury {
+		gs.record(Event{Kind: EventTreasuryChanged, Treasury: sd.Treasury})
+	}
+	for _, u := range sd.Upserted {
+		u = gs.Rules.UpgradeUnit(u)
+		old, ok := gs.Player.Units[u.ID]
+		switch {
+		case !ok:
+			gs.record(Event{Kind: EventUnitSpawned, Unit: &u})
+		case old == u:
+		case old.Location != u.Location:
+			gs.record(Event{Kind: EventUnitMoved, Unit: &u})
+		default:
+			gs.record(Event{Kind: EventUnitChanged, Unit: &u})
+		}
+	}
+	killed := []int{}
+	for _, id := range sd.Removed {
+		if u, ok := gs.Player.Units[id]; ok {
+			fmt.Printf("Your %s %v in %s was killed.\n", u.Rank, u.ID, u.Location)
+			killed = append(killed, id)
+		}
+	}
+	if len(killed) > 0 {
+		gs.record(Event{Kind: EventUnitsKilled, UnitIDs: killed})
+	}
+}
@@ -0,0 +1,260 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Relation string
+
+const (
+	RelationWar      Relation = "war"
+	RelationTruce    Relation = "truce"
+	RelationAlliance Relation = "alliance"
+	RelationBetrayed Relation = "betrayed"
+)
+
+const (
+	DiplomacyAlly  = "ally"
+	DiplomacyTruce = "truce"
+	DiplomacyBreak = "break"
+)
+
+// DiplomaticAction is a player asking the server to change their relation
+// with another player. Proposing what the other side has already proposed
+// accepts it.
+type DiplomaticAction struct {
+	From string
+	To   string
+	Kind string
+}
+
+func (da DiplomaticAction) Claimant() string {
+	return da.From
+}
+
+// Treaty is the relation between two players, whose names are kept sorted.
+// Every pair starts at war. Proposal is the relation ProposedBy has offered
+// and is waiting on the other player to accept. BrokenBy is whoever last
+// broke a truce or alliance.
+type Treaty struct {
+	Players    [2]string
+	Relation   Relation
+	Proposal   Relation
+	ProposedBy string
+	BrokenBy   string
+	Turn       int
+}
+
+// TreatyUpdate tells a player one of their treaties changed.
+type TreatyUpdate struct {
+	Username string
+	Treaty   Treaty
+}
+
+func (tu TreatyUpdate) Claimant() string {
+	return tu.Username
+}
+
+func treatyKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+func newTreaty(a, b string) Treaty {
+	if b < a {
+		a, b = b, a
+	}
+	return Treaty{
+		Players:  [2]string{a, b},
+		Relation: RelationWar,
+	}
+}
+
+// Hostile reports whether the two players fight when they meet.
+func (t Treaty) Hostile() bool {
+	return t.Relation == RelationWar || t.Relation == RelationBetrayed
+}
+
+// Other returns the player on the other side of the treaty from username.
+func (t Treaty) Other(username string) string {
+	if t.Players[0] == username {
+		return t.Players[1]
+	}
+	return t.Players[0]
+}
+
+// Apply moves the treaty through the diplomacy state machine:
+//
+//	war/betrayed/truce --ally/truce--> proposal --matching proposal--> alliance/truce
+//	alliance --break--> betrayed
+//	truce --break--> war
+//
+// Breaking while a proposal is pending withdraws or declines it instead.
+func (t Treaty) Apply(da DiplomaticAction, turn int) (Treaty, error) {
+	switch da.Kind {
+	case DiplomacyAlly, DiplomacyTruce:
+		want := RelationAlliance
+		if da.Kind == DiplomacyTruce {
+			want = RelationTruce
+		}
+		if t.Relation == want {
+			return t, fmt.Errorf("you already have a(n) %s with %s", want, da.To)
+		}
+		if t.Proposal == want && t.ProposedBy != da.From {
+			t.Relation = want
+			t.Proposal, t.ProposedBy = "", ""
+		} else {
+			t.Proposal, t.ProposedBy = want, da.From
+		}
+	case DiplomacyBreak:
+		switch {
+		case t.Proposal != "":
+			t.Proposal, t.ProposedBy = "", ""
+		case t.Relation == RelationAlliance:
+			t.Relation, t.BrokenBy = RelationBetrayed, da.From
+		case t.Relation == RelationTruce:
+			t.Relation, t.BrokenBy = RelationWar, da.From
+		default:
+			return t, fmt.Errorf("you have nothing to break with %s", da.To)
+		}
+	default:
+		return t, fmt.Errorf("unknown diplomatic action: %q", da.Kind)
+	}
+	t.Turn = turn
+	return t, nil
+}
+
+// ApplyDiplomacy applies a diplomatic action to the canonical treaties and
+// returns the updated treaty.
+func (w *World) ApplyDiplomacy(da DiplomaticAction) (Treaty, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return Treaty{}, errGameOver
+	}
+	if da.From == da.To {
+		return Treaty{}, errors.New("you can not make a treaty with yourself")
+	}
+	if _, ok := w.Players[da.To]; !ok {
+		return Treaty{}, fmt.Errorf("%s is not playing", da.To)
+	}
+	_, err := w.lookup(da.From)
+	if err != nil {
+		return Treaty{}, err
+	}
+	t, err := w.treaty(da.From, da.To).Apply(da, w.Turn)
+	if err != nil {
+		return Treaty{}, err
+	}
+	w.Treaties[treatyKey(da.From, da.To)] = t
+	return t, nil
+}
+
+func (w *World) treaty(a, b string) Treaty {
+	t, ok := w.Treaties[treatyKey(a, b)]
+	if !ok {
+		return newTreaty(a, b)
+	}
+	return t
+}
+
+func (w *World) hostile(a, b string) bool {
+	return w.treaty(a, b).Hostile()
+}
+
+// CommandDiplomacy parses "ally <user>", "truce <user>" and "break <user>".
+func (gs *GameState) CommandDiplomacy(words []string) (DiplomaticAction, error) {
+	if gs.isOver() {
+		return DiplomaticAction{}, errGameOver
+	}
+	if len(words) < 2 {
+		return DiplomaticAction{}, fmt.Errorf("usage: %s <user>", words[0])
+	}
+	to := words[1]
+	if to == gs.GetUsername() {
+		return DiplomaticAction{}, errors.New("you can not make a treaty with yourself")
+	}
+	return DiplomaticAction{
+		From: gs.GetUsername(),
+		To:   to,
+		Kind: words[0],
+	}, nil
+}
+
+// HandleTreatyUpdate records a treaty the server changed.
+func (gs *GameState) HandleTreatyUpdate(tu TreatyUpdate) {
+	defer fmt.Println("------------------------")
+	t := tu.Treaty
+	me := gs.GetUsername()
+	other := t.Other(me)
+	gs.mu.Lock()
+	gs.Treaties[treatyKey(t.Players[0], t.Players[1])] = t
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	switch {
+	case t.Proposal != "" && t.ProposedBy == me:
+		fmt.Printf("You offered %s a(n) %s.\n", other, t.Proposal)
+	case t.Proposal != "":
+		fmt.Printf("%s offers you a(n) %s. Reply with: %s %s\n", other, t.Proposal, proposalCommand(t.Proposal), other)
+	case t.Relation == RelationBetrayed && t.BrokenBy == me:
+		fmt.Printf("You betrayed your alliance with %s.\n", other)
+	case t.Relation == RelationBetrayed:
+		fmt.Printf("%s has betrayed you!\n", other)
+	default:
+		fmt.Printf("You are now at %s with %s.\n", t.Relation, other)
+	}
+}
+
+func proposalCommand(r Relation) string {
+	if r == RelationTruce {
+		return DiplomacyTruce
+	}
+	return DiplomacyAlly
+}
+
+func (gs *GameState) isHostile(username string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	t, ok := gs.Treaties[treatyKey(gs.Player.Username, username)]
+	return !ok || t.Hostile()
+}
+
+func (gs *GameState) GetTreatiesSnap() []Treaty {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	treaties := []Treaty{}
+	for _, t := range gs.Treaties {
+		treaties = append(treaties, t)
+	}
+	return treaties
+}
+
+// CommandDiplomacyStatus prints every treaty you are part of.
+func (gs *GameState) CommandDiplomacyStatus() {
+	treaties := gs.GetTreatiesSnap()
+	if len(treaties) == 0 {
+		fmt.Println("You are at war with everyone.")
+		return
+	}
+	me := gs.GetUsername()
+	slices.SortFunc(treaties, func(a, b Treaty) int {
+		return strings.Compare(a.Other(me), b.Other(me))
+	})
+	for _, t := range treaties {
+		line := fmt.Sprintf("* %s: %s", t.Other(me), t.Relation)
+		if t.Proposal != "" {
+			line += fmt.Sprintf(" (%s proposed a(n) %s)", t.ProposedBy, t.Proposal)
+		}
+		if t.BrokenBy != "" {
+			line += fmt.Sprintf(" (last broken by %s)", t.BrokenBy)
+		}
+		fmt.Println(line)
+	}
+}
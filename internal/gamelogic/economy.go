@@ -0,0 +1,76 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+)
+
+// collectIncome pays every player for the locations they own and charges
+// them upkeep for their army. A player who can not pay has units disbanded,
+// newest first, until they can.
+func (w *World) collectIncome(deltaFor func(string) *StateDelta) {
+	for _, name := range w.usernames() {
+		p := w.Players[name]
+		units := []Unit{}
+		for _, u := range p.Units {
+			units = append(units, u)
+		}
+		income := len(w.ownedLocations(name)) * w.Rules.Economy.IncomePerLocation
+		balance := w.Treasury[name] + income - w.Rules.Upkeep(units)
+
+		slices.SortFunc(units, func(a, b Unit) int {
+			return b.ID - a.ID
+		})
+		sd := deltaFor(name)
+		for _, u := range units {
+			if balance >= 0 {
+				break
+			}
+			rr, _ := w.Rules.Rank(u.Rank)
+			if rr.Upkeep == 0 {
+				continue
+			}
+			delete(p.Units, u.ID)
+			balance += rr.Upkeep
+			sd.Removed = append(sd.Removed, u.ID)
+		}
+		w.Treasury[name] = balance
+	}
+}
+
+func (gs *GameState) GetTreasury() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Treasury
+}
+
+// spend takes the cost of a spawn from the local treasury until the server
+// confirms the real balance.
+func (gs *GameState) spend(cost int) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.Treasury < cost {
+		return fmt.Errorf("that costs %d but you only have %d", cost, gs.Treasury)
+	}
+	gs.record(Event{Kind: EventTreasuryChanged, Treasury: gs.Treasury - cost})
+	return nil
+}
+
+// CommandTreasury prints your balance, what your army costs to keep and what
+// each rank costs to spawn.
+func (gs *GameState) CommandTreasury() {
+	rules := gs.GetRules()
+	p := gs.GetPlayerSnap()
+	units := []Unit{}
+	for _, u := range p.Units {
+		units = append(units, u)
+	}
+
+	fmt.Printf("You have %d in the treasury.\n", gs.GetTreasury())
+	fmt.Printf("Your army costs %d per turn to keep.\n", rules.Upkeep(units))
+	fmt.Printf("You earn %d per turn for each location you own (you own %d).\n", rules.Economy.IncomePerLocation, len(gs.GetOwnedLocations()))
+	fmt.Println("Spawn costs:")
+	for _, rr := range rules.Ranks {
+		fmt.Printf("* %s: %d (upkeep %d)\n", rr.Rank, rr.Cost, rr.Upkeep)
+	}
+}
@@ -0,0 +1,166 @@
+package gamelogic
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	EventUnitSpawned     = "unit_spawned"
+	EventUnitMoved       = "unit_moved"
+	EventUnitChanged     = "unit_changed"
+	EventUnitsKilled     = "units_killed"
+	EventUnitIDReserved  = "unit_id_reserved"
+	EventArmyReset       = "army_reset"
+	EventBattleResolved  = "battle_resolved"
+	EventGamePaused      = "game_paused"
+	EventGameResumed     = "game_resumed"
+	EventTurnStarted     = "turn_started"
+	EventTreasuryChanged = "treasury_changed"
+	EventRulesAdopted    = "rules_adopted"
+	EventGameLoaded      = "game_loaded"
+	EventGameOver        = "game_over"
+)
+
+// Event is one change to a GameState. Every change to the player's army,
+// treasury, turn and pause state is made by folding an event, so a journal of
+// them can rebuild the game. Only the fields Kind needs are set. Messages,
+// treaties and what the player can see of others are not part of it.
+type Event struct {
+	Seq      int
+	Kind     string
+	At       time.Time
+	Unit     *Unit
+	UnitIDs  []int
+	Army     map[int]Unit
+	Battle   *BattleReport
+	Turn     int
+	Seed     int64
+	Owners   map[Location]string
+	Treasury int
+	Rules    *Ruleset
+	Save     *ClientSave
+	GameOver *GameOver
+}
+
+// record applies e and appends it to the journal, if there is one. gs.mu must
+// be held.
+func (gs *GameState) record(e Event) {
+	e.At = time.Now()
+	gs.fold(e)
+	if gs.journal == nil {
+		return
+	}
+	err := gs.journal.append(e, gs)
+	if err != nil {
+		fmt.Println(fmt.Errorf("could not journal event: %v", err))
+	}
+}
+
+// fold applies e to the state. It must not have any other effect, so that
+// replaying the same events always rebuilds the same state. gs.mu must be
+// held.
+func (gs *GameState) fold(e Event) {
+	switch e.Kind {
+	case EventUnitSpawned:
+		gs.Player.Units[e.Unit.ID] = *e.Unit
+		gs.UnitIDs.Observe(e.Unit.ID)
+	case EventUnitMoved, EventUnitChanged:
+		gs.Player.Units[e.Unit.ID] = *e.Unit
+	case EventUnitsKilled:
+		for _, id := range e.UnitIDs {
+			delete(gs.Player.Units, id)
+		}
+	case EventUnitIDReserved:
+		for _, id := range e.UnitIDs {
+			gs.UnitIDs.Observe(id)
+		}
+	case EventArmyReset:
+		gs.Player.Units = map[int]Unit{}
+		for id, u := range e.Army {
+			gs.Player.Units[id] = u
+		}
+	case EventBattleResolved:
+		// Only our own side of the battle is ours to change.
+		br := e.Battle
+		losses, survivors := br.AttackerLosses, br.AttackerSurvivors
+		if br.Defender == gs.Player.Username {
+			losses, survivors = br.DefenderLosses, br.DefenderSurvivors
+		} else if br.Attacker != gs.Player.Username {
+			return
+		}
+		for _, id := range losses {
+			delete(gs.Player.Units, id)
+		}
+		for _, u := range survivors {
+			gs.Player.Units[u.ID] = u
+		}
+	case EventGamePaused:
+		gs.Paused = true
+	case EventGameResumed:
+		gs.Paused = false
+	case EventTurnStarted:
+		gs.Turn = e.Turn
+		gs.Seed = e.Seed
+		gs.Owners = e.Owners
+	case EventTreasuryChanged:
+		gs.Treasury = e.Treasury
+	case EventRulesAdopted:
+		gs.Rules = e.Rules
+	case EventGameLoaded:
+		gs.restore(*e.Save)
+	case EventGameOver:
+		gs.GameOver = e.GameOver
+	}
+}
+
+// Describe says what happened in a single line, for the replay tool.
+func (e Event) Describe() string {
+	switch e.Kind {
+	case EventUnitSpawned:
+		return fmt.Sprintf("%s %v spawned in %s", e.Unit.Rank, e.Unit.ID, e.Unit.Location)
+	case EventUnitMoved:
+		return fmt.Sprintf("%s %v moved to %s", e.Unit.Rank, e.Unit.ID, e.Unit.Location)
+	case EventUnitChanged:
+		return fmt.Sprintf("%s %v now has %d health and %d xp", e.Unit.Rank, e.Unit.ID, e.Unit.Health, e.Unit.XP)
+	case EventUnitsKilled:
+		return fmt.Sprintf("unit(s) %v were killed", e.UnitIDs)
+	case EventUnitIDReserved:
+		return fmt.Sprintf("unit ID(s) %v were reserved", e.UnitIDs)
+	case EventArmyReset:
+		return fmt.Sprintf("the army was reset to %d unit(s)", len(e.Army))
+	case EventBattleResolved:
+		return fmt.Sprintf("%s attacked %s in %s and %s won", e.Battle.Attacker, e.Battle.Defender, e.Battle.Location, e.Battle.Winner)
+	case EventGamePaused:
+		return "the game was paused"
+	case EventGameResumed:
+		return "the game was resumed"
+	case EventTurnStarted:
+		return fmt.Sprintf("turn %d started", e.Turn)
+	case EventTreasuryChanged:
+		return fmt.Sprintf("the treasury is now %d", e.Treasury)
+	case EventRulesAdopted:
+		return fmt.Sprintf("playing by the %s ruleset (version %d)", e.Rules.Name, e.Rules.Version)
+	case EventGameLoaded:
+		return fmt.Sprintf("a save from turn %d was loaded", e.Save.Turn)
+	case EventGameOver:
+		return fmt.Sprintf("the game is over: %v %s", e.GameOver.Winners, e.GameOver.Reason)
+	default:
+		return fmt.Sprintf("unknown event: %q", e.Kind)
+	}
+}
+
+// Replay rebuilds username's GameState by folding events in order. step, when
+// set, is called after each event with the state so far.
+func Replay(username string, events []Event, step func(Event, *GameState)) *GameState {
+	gs := NewGameState(username)
+	for _, e := range events {
+		gs.mu.Lock()
+		gs.fold(e)
+		gs.mu.Unlock()
+		if step != nil {
+			step(e, gs)
+		}
+	}
+	return gs
+}
@@ -13,40 +13,136 @@ const (
 	RankArtillery = "artillery"
 )
 
+// UnitVersion is the current shape of a Unit on the wire. Units from before
+// versioning have a Version of 0 and no Health or XP; Ruleset.UpgradeUnit
+// brings them up to date.
+const UnitVersion = 1
+
+// Unit is one piece of a player's army. Health is what it has left of its
+// rank's Health, and XP the experience it has earned surviving battles.
 type Unit struct {
+	Version  int
 	ID       int
 	Rank     UnitRank
 	Location Location
+	Health   int
+	XP       int
 }
 
+// ArmyMove orders units to ToLocation, a neighbouring location unless they
+// are given Via, the locations to pass through in order. Only the player's
+// username and the IDs of the units are sent; the server looks up the rest.
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
+	Via        []Location `json:",omitempty"`
 }
 
-type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+// Claimant is the player who published the move.
+func (am ArmyMove) Claimant() string {
+	return am.Player.Username
 }
 
 type Location string
 
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
-}
-
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
+// SpawnOrder asks the server to add a unit. The client picks the unit's ID
+// and pays for it up front, but the unit only joins its army once the server's
+// delta says so.
+type SpawnOrder struct {
+	Username string
+	Unit     Unit
+}
+
+func (so SpawnOrder) Claimant() string {
+	return so.Username
+}
+
+// TurnSubmission tells the server a player has no more orders this turn.
+type TurnSubmission struct {
+	Username string
+	Turn     int
+}
+
+func (ts TurnSubmission) Claimant() string {
+	return ts.Username
+}
+
+// BattleReport describes one battle fought when a turn was resolved. Outcome
+// is from the attacker's point of view. The units that fought are included so
+// the battle can be replayed with the game seed, and the survivors carry their
+// wounds and experience after it.
+type BattleReport struct {
+	Location          Location
+	Attacker          string
+	Defender          string
+	Outcome           WarOutcome
+	Winner            string
+	Loser             string
+	AttackerUnits     []Unit
+	DefenderUnits     []Unit
+	AttackerLosses    []int
+	DefenderLosses    []int
+	AttackerSurvivors []Unit
+	DefenderSurvivors []Unit
+}
+
+// WarSummary combines every battle two players fought against each other.
+// Outcome is from the attacker's point of view, decided by who won more of
+// the battles.
+type WarSummary struct {
+	Attacker  string
+	Defender  string
+	Locations []Location
+	Outcome   WarOutcome
+	Winner    string
+	Loser     string
+}
+
+// TurnResult describes a resolved turn. Each player is sent their own copy,
+// filtered down to what Viewer can see: the Visible locations and the Enemies
+// in them. Seed is the game-wide combat seed. GameOver is set on the last
+// turn.
+type TurnResult struct {
+	Turn     int
+	Seed     int64
+	Viewer   string
+	Visible  []Location
+	Enemies  []Player
+	Moves    []ArmyMove
+	Battles  []BattleReport
+	Wars     []WarSummary
+	Choices  []BattleChoice
+	Owners   map[Location]string
+	Scores   map[string]int
+	GameOver *GameOver
+}
+
+// Claimant is the player the view was filtered for. Views are only ever
+// signed by the server.
+func (tr TurnResult) Claimant() string {
+	return tr.Viewer
+}
+
+// StateDelta is the server's correction of a player's army. Snapshot, when
+// set, replaces the whole army before the rest of the delta is applied.
+// LastUnitID is the highest unit ID the server has seen from the player, and
+// Treasury their balance after the change. Turn is the turn the army is at
+// once the delta is applied; every player gets one as each turn is resolved,
+// so a client can tell when its army has caught up with a turn result.
+type StateDelta struct {
+	Username   string
+	Turn       int
+	Upserted   []Unit
+	Removed    []int
+	Rejected   string
+	Snapshot   *Player
+	LastUnitID int
+	Treasury   int
+}
+
+// Claimant is the player the delta is for. Deltas are only ever signed by the
+// server.
+func (sd StateDelta) Claimant() string {
+	return sd.Username
 }
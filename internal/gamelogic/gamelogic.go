@@ -11,13 +11,44 @@ import (
 
 func PrintClientHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
+	fmt.Println("* move <location> <unitID> <unitID> <unitID>... [via <location>...]")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
+	fmt.Println("    move asia 1 via africa")
+	fmt.Println("    (orders take effect when the turn ends; a unit moves to a neighbouring")
+	fmt.Println("    location, or further along the route given with via, as long as the")
+	fmt.Println("    travel time fits its rank's speed and it can cross the terrain)")
+	fmt.Println("* route <from> <to> [rank]")
+	fmt.Println("    example:")
+	fmt.Println("    route europe australia cavalry")
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* promote <unitID>")
+	fmt.Println("    (a unit with enough experience becomes the next rank at full health)")
+	fmt.Println("* fight <location>")
+	fmt.Println("* retreat <location> <to>")
+	fmt.Println("* surrender <location> [unitID...]")
+	fmt.Println("    (answer a battle coming your way, before its deadline)")
+	fmt.Println("* done")
+	fmt.Println("    (you have no more orders this turn)")
 	fmt.Println("* status")
+	fmt.Println("* treasury")
+	fmt.Println("* whisper <user> <text>")
+	fmt.Println("    example:")
+	fmt.Println("    whisper bob let's team up against alice")
+	fmt.Println("* inbox")
+	fmt.Println("* ally <user>")
+	fmt.Println("* truce <user>")
+	fmt.Println("    (propose, or accept what <user> proposed)")
+	fmt.Println("* break <user>")
+	fmt.Println("    (break a treaty, or withdraw or decline a proposal)")
+	fmt.Println("* diplomacy")
+	fmt.Println("* save <name>")
+	fmt.Println("* load <name>")
+	fmt.Println("    (the server's next update replaces anything that changed since)")
+	fmt.Println("* leave")
+	fmt.Println("    (give up your place in the game, and quit)")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -34,14 +65,18 @@ func ClientWelcome() (string, error) {
 	}
 	username := words[0]
 	fmt.Printf("Welcome, %s!\n", username)
-	PrintClientHelp()
 	return username, nil
 }
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* games")
+	fmt.Println("* players [game]")
+	fmt.Println("* pause [game]")
+	fmt.Println("* resume [game]")
+	fmt.Println("* save <name> [game]")
+	fmt.Println("* load <name> [game]")
+	fmt.Println("    (without a game, the default one)")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -86,8 +121,16 @@ func (gs *GameState) CommandStatus() {
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	rules := gs.GetRules()
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		rr, _ := rules.Rank(unit.Rank)
+		fmt.Printf("* %v: %v, %v (health %d/%d, xp %d, level %d)\n", unit.ID, unit.Location, unit.Rank, unit.Health, rr.Health, unit.XP, rules.Level(unit))
+	}
+	for _, enemy := range gs.GetEnemiesSnap() {
+		fmt.Printf("You can see %d of %s's units:\n", len(enemy.Units), enemy.Username)
+		for _, unit := range enemy.Units {
+			fmt.Printf("* %v, %v\n", unit.Location, unit.Rank)
+		}
 	}
 }
 
@@ -5,9 +5,22 @@ import (
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player         Player
+	Paused         bool
+	Turn           int
+	Seed           int64
+	Treasury       int
+	Owners         map[Location]string
+	Enemies        []Player
+	Treaties       map[string]Treaty
+	BattleWarnings []BattleWarning
+	GameOver       *GameOver
+	Inbox          []PrivateMessage
+	UnitIDs        UnitIDAllocator
+	Map            *Map
+	Rules          *Ruleset
+	mu             *sync.RWMutex
+	journal        *Journal
 }
 
 func NewGameState(username string) *GameState {
@@ -16,21 +29,25 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:   false,
+		Treasury: DefaultRuleset().Economy.StartingFunds,
+		Treaties: map[string]Treaty{},
+		Map:      DefaultMap(),
+		Rules:    DefaultRuleset(),
+		mu:       &sync.RWMutex{},
 	}
 }
 
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = false
+	gs.record(Event{Kind: EventGameResumed})
 }
 
 func (gs *GameState) pauseGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = true
+	gs.record(Event{Kind: EventGamePaused})
 }
 
 func (gs *GameState) isPaused() bool {
@@ -39,40 +56,34 @@ func (gs *GameState) isPaused() bool {
 	return gs.Paused
 }
 
-func (gs *GameState) addUnit(u Unit) {
+func (gs *GameState) nextUnitID() int {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+	id := gs.UnitIDs.Last + 1
+	gs.record(Event{Kind: EventUnitIDReserved, UnitIDs: []int{id}})
+	return id
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) addToInbox(m PrivateMessage) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
-	}
-}
-
-func (gs *GameState) UpdateUnit(u Unit) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Player.Units[u.ID] = u
+	gs.Inbox = append(gs.Inbox, m)
 }
 
-func (gs *GameState) GetUsername() string {
-	return gs.Player.Username
+func (gs *GameState) GetInboxSnap() []PrivateMessage {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]PrivateMessage{}, gs.Inbox...)
 }
 
-func (gs *GameState) getUnitsSnap() []Unit {
+func (gs *GameState) GetRules() *Ruleset {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
-	Units := []Unit{}
-	for _, v := range gs.Player.Units {
-		Units = append(Units, v)
-	}
-	return Units
+	return gs.Rules
+}
+
+func (gs *GameState) GetUsername() string {
+	return gs.Player.Username
 }
 
 func (gs *GameState) GetUnit(id int) (Unit, bool) {
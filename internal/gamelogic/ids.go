@@ -0,0 +1,22 @@
+package gamelogic
+
+// UnitIDAllocator hands out unit IDs for one player. IDs only ever increase,
+// so an ID is never reused after its unit dies, and paired with the username
+// an ID is unique across the whole game. It is exported so it can be saved
+// along with the army.
+type UnitIDAllocator struct {
+	Last int
+}
+
+func (a *UnitIDAllocator) Next() int {
+	a.Last++
+	return a.Last
+}
+
+// Observe moves the allocator past an ID that was handed out elsewhere, for
+// example by the server before a restart.
+func (a *UnitIDAllocator) Observe(id int) {
+	if id > a.Last {
+		a.Last = id
+	}
+}
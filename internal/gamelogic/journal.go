@@ -0,0 +1,190 @@
+package gamelogic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SnapshotEvery is how many events are journaled between snapshots.
+const SnapshotEvery = 100
+
+const saveKindSnapshot = "snapshot"
+
+// Journal appends every event applied to a GameState to a file, one JSON
+// event per line. Every SnapshotEvery events it also saves the whole state
+// along with where in the file it was taken, so resuming only has to fold the
+// events after it.
+type Journal struct {
+	file         *os.File
+	snapshotPath string
+	seq          int
+	offset       int64
+}
+
+// journalSnapshot is the state after event Seq, which ends Offset bytes into
+// the journal.
+type journalSnapshot struct {
+	Seq    int
+	Offset int64
+	State  ClientSave
+}
+
+// JournalPaths returns where username's events and latest snapshot are kept.
+func JournalPaths(username string) (events, snapshot string, err error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir = filepath.Join(dir, "peril", "journal")
+	return filepath.Join(dir, username+".events"), filepath.Join(dir, username+".snapshot"), nil
+}
+
+// OpenJournal starts journaling gs. With resume, gs is first rebuilt from the
+// latest snapshot and the events journaled after it; otherwise a new journal
+// is started.
+func (gs *GameState) OpenJournal(resume bool) error {
+	eventsPath, snapshotPath, err := JournalPaths(gs.GetUsername())
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(eventsPath), 0700)
+	if err != nil {
+		return fmt.Errorf("could not create journal directory: %v", err)
+	}
+	j := &Journal{snapshotPath: snapshotPath}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if resume {
+		err = j.resume(gs, eventsPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.Remove(snapshotPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not reset journal: %v", err)
+		}
+		err = os.Truncate(eventsPath, 0)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not reset journal: %v", err)
+		}
+	}
+	j.file, err = os.OpenFile(eventsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open journal: %v", err)
+	}
+	gs.journal = j
+	return nil
+}
+
+// resume rebuilds gs from the snapshot and the events after it. gs.mu must be
+// held.
+func (j *Journal) resume(gs *GameState, eventsPath string) error {
+	snap := journalSnapshot{}
+	err := readSave(j.snapshotPath, saveKindSnapshot, &snap)
+	if err == nil {
+		rules, err := loadRules(snap.State.Rules, gs.Map)
+		if err != nil {
+			return err
+		}
+		snap.State.Rules = *rules
+		gs.restore(snap.State)
+		j.seq, j.offset = snap.Seq, snap.Offset
+	} else if _, statErr := os.Stat(j.snapshotPath); statErr == nil {
+		return err
+	}
+
+	events, offset, err := readEvents(eventsPath, j.offset)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		gs.fold(e)
+		j.seq = e.Seq
+	}
+	// Anything after the last whole event is dropped, so new events start on
+	// a line of their own.
+	err = os.Truncate(eventsPath, offset)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not repair journal: %v", err)
+	}
+	j.offset = offset
+	return nil
+}
+
+// append writes e to the journal, taking a snapshot of gs when one is due.
+// gs.mu must be held.
+func (j *Journal) append(e Event, gs *GameState) error {
+	j.seq++
+	e.Seq = j.seq
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := j.file.Write(append(data, '\n'))
+	j.offset += int64(n)
+	if err != nil {
+		return err
+	}
+	if j.seq%SnapshotEvery != 0 {
+		return nil
+	}
+	return writeSave(j.snapshotPath, saveKindSnapshot, journalSnapshot{
+		Seq:    j.seq,
+		Offset: j.offset,
+		State:  gs.clientSave(),
+	})
+}
+
+// ReadJournal returns every event journaled for username, oldest first.
+func ReadJournal(username string) ([]Event, error) {
+	eventsPath, _, err := JournalPaths(username)
+	if err != nil {
+		return nil, err
+	}
+	events, _, err := readEvents(eventsPath, 0)
+	return events, err
+}
+
+// readEvents reads the events written after offset, and returns the offset
+// the journal ends at. A torn last line, from a crash half-way through a
+// write, is ignored.
+func readEvents(path string, offset int64) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, offset, nil
+	}
+	if err != nil {
+		return nil, offset, fmt.Errorf("could not open journal: %v", err)
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, offset, fmt.Errorf("could not read journal: %v", err)
+	}
+
+	events := []Event{}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return events, offset, nil
+		}
+		if err != nil {
+			return nil, offset, fmt.Errorf("could not read journal: %v", err)
+		}
+		e := Event{}
+		err = json.Unmarshal(line, &e)
+		if err != nil {
+			return nil, offset, fmt.Errorf("journal is corrupt at byte %d: %v", offset, err)
+		}
+		events = append(events, e)
+		offset += int64(len(line))
+	}
+}
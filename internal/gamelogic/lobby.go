@@ -0,0 +1,363 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	LobbyList   = "list"
+	LobbyCreate = "create"
+	LobbyJoin   = "join"
+	LobbyLeave  = "leave"
+	LobbyResume = "resume"
+)
+
+// maxGameIDLength keeps game IDs short enough to read in a routing key.
+const maxGameIDLength = 32
+
+// LobbyRequest asks the server's lobby to list its games, or to create, join
+// or leave one. Resuming takes the token handed out when the player joined,
+// and the turn their client was on. ID comes back in the reply, so a reply to
+// an earlier request that arrives late is never taken for this one's.
+// ReplyTo names the private queue the reply goes to; it is signed with the
+// rest of the request, so nobody can redirect someone else's reply.
+type LobbyRequest struct {
+	ID         string
+	ReplyTo    string
+	Username   string
+	Action     string
+	GameID     string
+	MaxPlayers int
+	Token      string
+	Turn       int
+}
+
+func (lr LobbyRequest) Claimant() string {
+	return lr.Username
+}
+
+// LobbyReply answers a LobbyRequest. Error is set when it was refused. Token
+// is set when the player joins or resumes, and Snapshot when they resume.
+type LobbyReply struct {
+	RequestID string
+	Username  string
+	Action    string
+	GameID    string
+	Games     []GameInfo
+	Token     string
+	Snapshot  *PlayerSnapshot
+	Error     string
+}
+
+// Claimant is the player who asked. Replies are only ever signed by the
+// server.
+func (lr LobbyReply) Claimant() string {
+	return lr.Username
+}
+
+// NewRequestID returns a fresh ID for a lobby request.
+func NewRequestID() (string, error) {
+	return newToken()
+}
+
+// GameInfo is what the lobby shows of a game.
+type GameInfo struct {
+	ID         string
+	Players    []string
+	MaxPlayers int
+	Turn       int
+	Paused     bool
+	Over       bool
+}
+
+// Lobby is every game a server hosts. The default game, with an empty ID, is
+// always there; the rest are created by players, at most maxGames at a time.
+type Lobby struct {
+	games    map[string]*World
+	sessions map[string]Session
+	rules    *Ruleset
+	maxGames int
+	mu       *sync.RWMutex
+}
+
+// NewLobby hosts world as the default game. Games created later are played by
+// the same rules, and players can create up to maxGames of them, or any
+// number when maxGames is 0.
+func NewLobby(world *World, maxGames int) *Lobby {
+	return &Lobby{
+		games:    map[string]*World{world.ID: world},
+		sessions: map[string]Session{},
+		rules:    world.Rules,
+		maxGames: maxGames,
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// ValidGameID checks that id can be used in routing keys and queue names.
+func ValidGameID(id string) error {
+	if id == "" || len(id) > maxGameIDLength {
+		return fmt.Errorf("a game ID must be 1 to %d characters long", maxGameIDLength)
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("%q is not a valid game ID: use lowercase letters, digits, - and _", id)
+		}
+	}
+	return nil
+}
+
+// Create starts a new game for at most maxPlayers players, or any number when
+// maxPlayers is 0.
+func (l *Lobby) Create(id string, maxPlayers int) (*World, error) {
+	err := ValidGameID(id)
+	if err != nil {
+		return nil, err
+	}
+	if maxPlayers < 0 {
+		return nil, errors.New("the player limit can not be negative")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.games[id]; ok {
+		return nil, fmt.Errorf("there is already a game called %s", id)
+	}
+	// The default game does not count against the limit.
+	if l.maxGames > 0 && len(l.games)-1 >= l.maxGames {
+		return nil, errors.New("the server is hosting as many games as it can, try again later")
+	}
+	w := NewWorld()
+	w.ID = id
+	w.MaxPlayers = maxPlayers
+	w.Rules = l.rules
+	w.Seed = time.Now().UnixNano()
+	l.games[id] = w
+	return w, nil
+}
+
+// Remove closes the game called id, along with every session issued for it.
+// The default game is never removed.
+func (l *Lobby) Remove(id string) error {
+	if id == "" {
+		return errors.New("the default game can not be removed")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.games[id]; !ok {
+		return fmt.Errorf("there is no game called %s", id)
+	}
+	delete(l.games, id)
+	for token, s := range l.sessions {
+		if s.GameID == id {
+			delete(l.sessions, token)
+		}
+	}
+	return nil
+}
+
+// Game returns the game called id.
+func (l *Lobby) Game(id string) (*World, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	w, ok := l.games[id]
+	return w, ok
+}
+
+// List describes every game, ordered by ID.
+func (l *Lobby) List() []GameInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	games := []GameInfo{}
+	for _, w := range l.games {
+		games = append(games, w.Info())
+	}
+	slices.SortFunc(games, func(a, b GameInfo) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		if a.ID > b.ID {
+			return 1
+		}
+		return 0
+	})
+	return games
+}
+
+// issue hands out a session token for username in gameID, replacing any they
+// were given before.
+func (l *Lobby) issue(gameID, username string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", fmt.Errorf("could not issue a session: %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for old, s := range l.sessions {
+		if s.GameID == gameID && s.Username == username {
+			delete(l.sessions, old)
+		}
+	}
+	l.sessions[token] = Session{
+		GameID:   gameID,
+		Username: username,
+		Token:    token,
+	}
+	return token, nil
+}
+
+// resume finds the game a session token was issued for.
+func (l *Lobby) resume(lr LobbyRequest) (*World, error) {
+	l.mu.RLock()
+	s, ok := l.sessions[lr.Token]
+	w := l.games[s.GameID]
+	l.mu.RUnlock()
+	if !ok || s.Username != lr.Username || s.GameID != lr.GameID {
+		return nil, errors.New("the session is not known, join the game again")
+	}
+	if !w.HasPlayer(lr.Username) {
+		return nil, fmt.Errorf("you are no longer playing in %s", GameName(lr.GameID))
+	}
+	return w, nil
+}
+
+// Handle carries out a lobby request. It returns the game the request was
+// about, if it names one that exists, along with the reply.
+func (l *Lobby) Handle(lr LobbyRequest) (LobbyReply, *World) {
+	reply := LobbyReply{
+		RequestID: lr.ID,
+		Username:  lr.Username,
+		Action:    lr.Action,
+		GameID:    lr.GameID,
+	}
+	var w *World
+	var err error
+	switch lr.Action {
+	case LobbyList:
+		reply.Games = l.List()
+		return reply, nil
+	case LobbyCreate:
+		w, err = l.Create(lr.GameID, lr.MaxPlayers)
+	case LobbyJoin, LobbyLeave:
+		var ok bool
+		w, ok = l.Game(lr.GameID)
+		if !ok {
+			err = fmt.Errorf("there is no game called %s", lr.GameID)
+		} else if lr.Action == LobbyJoin {
+			err = w.Join(lr.Username)
+			if err == nil {
+				reply.Token, err = l.issue(lr.GameID, lr.Username)
+			}
+		} else {
+			err = w.Leave(lr.Username)
+		}
+	case LobbyResume:
+		w, err = l.resume(lr)
+		if err == nil {
+			snap := w.Snapshot(lr.Username, lr.Turn)
+			reply.Snapshot = &snap
+			reply.Token = lr.Token
+		}
+	default:
+		err = fmt.Errorf("unknown lobby action: %q", lr.Action)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply, w
+}
+
+// GameName is how a game is shown to players.
+func GameName(id string) string {
+	if id == "" {
+		return "(default)"
+	}
+	return id
+}
+
+// PrintLobbyHelp lists what can be done before joining a game.
+func PrintLobbyHelp() {
+	fmt.Println("Pick a game to play:")
+	fmt.Println("* games")
+	fmt.Println("* create <game> [max players]")
+	fmt.Println("    example:")
+	fmt.Println("    create friday 4")
+	fmt.Println("    (you join the game once it is created)")
+	fmt.Println("* join [game]")
+	fmt.Println("    (without a game, you join the default one)")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
+// CommandLobby parses "games", "create <game> [max players]" and
+// "join [game]".
+func CommandLobby(username string, words []string) (LobbyRequest, error) {
+	lr := LobbyRequest{Username: username}
+	switch words[0] {
+	case "games":
+		lr.Action = LobbyList
+	case "create":
+		if len(words) < 2 {
+			return LobbyRequest{}, errors.New("usage: create <game> [max players]")
+		}
+		lr.Action = LobbyCreate
+		lr.GameID = words[1]
+		if len(words) > 2 {
+			n, err := strconv.Atoi(words[2])
+			if err != nil || n < 0 {
+				return LobbyRequest{}, fmt.Errorf("error: %s is not a valid player limit", words[2])
+			}
+			lr.MaxPlayers = n
+		}
+		err := ValidGameID(lr.GameID)
+		if err != nil {
+			return LobbyRequest{}, fmt.Errorf("error: %v", err)
+		}
+	case "join":
+		lr.Action = LobbyJoin
+		if len(words) > 1 {
+			lr.GameID = words[1]
+		}
+	default:
+		return LobbyRequest{}, fmt.Errorf("unknown command: %s", words[0])
+	}
+	return lr, nil
+}
+
+// PrintLobbyReply shows the lobby's answer to one of our requests.
+func PrintLobbyReply(reply LobbyReply) {
+	if reply.Error != "" {
+		fmt.Printf("The lobby refused: %s\n", reply.Error)
+		return
+	}
+	switch reply.Action {
+	case LobbyList:
+		fmt.Println()
+		fmt.Println("==== Games ====")
+		for _, g := range reply.Games {
+			limit := "no limit"
+			if g.MaxPlayers > 0 {
+				limit = fmt.Sprintf("%d max", g.MaxPlayers)
+			}
+			state := fmt.Sprintf("turn %d", g.Turn)
+			if g.Over {
+				state = "over"
+			} else if g.Paused {
+				state += ", paused"
+			}
+			fmt.Printf("* %s: %d player(s) (%s), %s\n", GameName(g.ID), len(g.Players), limit, state)
+		}
+		fmt.Println("------------------------")
+	case LobbyCreate:
+		fmt.Printf("Created %s.\n", GameName(reply.GameID))
+	case LobbyJoin:
+		fmt.Printf("Joined %s.\n", GameName(reply.GameID))
+	case LobbyLeave:
+		fmt.Printf("Left %s.\n", GameName(reply.GameID))
+	case LobbyResume:
+		fmt.Printf("Resumed %s.\n", GameName(reply.GameID))
+	}
+}
@@ -13,7 +13,8 @@ const logsFile = "game.log"
 
 const writeToDiskSleep = 1 * time.Second
 
-func WriteLog(gamelog routing.GameLog) error {
+// WriteLog appends a log from one of gameID's players to the log file.
+func WriteLog(gameID string, gamelog routing.GameLog) error {
 	log.Printf("received game log...")
 	time.Sleep(writeToDiskSleep)
 
@@ -23,7 +24,7 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	defer f.Close()
 
-	str := fmt.Sprintf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
+	str := fmt.Sprintf("%v [%v] %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), GameName(gameID), gamelog.Username, gamelog.Message)
 	_, err = f.WriteString(str)
 	if err != nil {
 		return fmt.Errorf("could not write to logs file: %v", err)
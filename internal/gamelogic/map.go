@@ -0,0 +1,217 @@
+package gamelogic
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type Terrain string
+
+const (
+	TerrainLand = "land"
+	TerrainSea  = "sea"
+	TerrainIce  = "ice"
+)
+
+// Edge connects two locations in both directions.
+type Edge struct {
+	From       Location
+	To         Location
+	TravelTime int
+	Terrain    Terrain
+}
+
+type Map struct {
+	Locations []Location
+	Edges     []Edge
+	adjacency map[Location]map[Location]Edge
+}
+
+//go:embed data/map.json
+var defaultMapData []byte
+
+var defaultMap = mustParseMap(defaultMapData)
+
+// DefaultMap returns the six continent map every game is played on.
+func DefaultMap() *Map {
+	return defaultMap
+}
+
+func ParseMap(data []byte) (*Map, error) {
+	m := &Map{}
+	err := json.Unmarshal(data, m)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse map: %v", err)
+	}
+	err = m.index()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func mustParseMap(data []byte) *Map {
+	m, err := ParseMap(data)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// index validates the map and builds its adjacency lookup.
+func (m *Map) index() error {
+	if len(m.Locations) == 0 {
+		return errors.New("map has no locations")
+	}
+	m.adjacency = map[Location]map[Location]Edge{}
+	for _, loc := range m.Locations {
+		if _, ok := m.adjacency[loc]; ok {
+			return fmt.Errorf("location %s is listed twice", loc)
+		}
+		m.adjacency[loc] = map[Location]Edge{}
+	}
+	for _, e := range m.Edges {
+		if _, ok := m.adjacency[e.From]; !ok {
+			return fmt.Errorf("edge from unknown location %s", e.From)
+		}
+		if _, ok := m.adjacency[e.To]; !ok {
+			return fmt.Errorf("edge to unknown location %s", e.To)
+		}
+		if e.From == e.To {
+			return fmt.Errorf("edge from %s to itself", e.From)
+		}
+		if e.TravelTime <= 0 {
+			return fmt.Errorf("edge from %s to %s needs a positive travel time", e.From, e.To)
+		}
+		m.adjacency[e.From][e.To] = e
+		m.adjacency[e.To][e.From] = Edge{
+			From:       e.To,
+			To:         e.From,
+			TravelTime: e.TravelTime,
+			Terrain:    e.Terrain,
+		}
+	}
+	return nil
+}
+
+func (m *Map) HasLocation(loc Location) bool {
+	_, ok := m.adjacency[loc]
+	return ok
+}
+
+// HasTerrain reports whether any edge on the map crosses terrain t.
+func (m *Map) HasTerrain(t Terrain) bool {
+	for _, e := range m.Edges {
+		if e.Terrain == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Adjacent reports whether an edge connects the two locations.
+func (m *Map) Adjacent(from, to Location) bool {
+	_, ok := m.adjacency[from][to]
+	return ok
+}
+
+func (m *Map) Edge(from, to Location) (Edge, bool) {
+	e, ok := m.adjacency[from][to]
+	return e, ok
+}
+
+// Neighbors returns the locations adjacent to loc in a stable order.
+func (m *Map) Neighbors(loc Location) []Location {
+	neighbors := []Location{}
+	for n := range m.adjacency[loc] {
+		neighbors = append(neighbors, n)
+	}
+	slices.Sort(neighbors)
+	return neighbors
+}
+
+// ShortestPath finds the quickest route between two locations, returning
+// every location along the way (both ends included) and the total travel
+// time. Ties are broken by location name so every caller gets the same route.
+func (m *Map) ShortestPath(from, to Location) ([]Location, int, error) {
+	return m.shortestPath(from, to, nil)
+}
+
+// shortestPath is ShortestPath over only the edges passable allows, or every
+// edge when passable is nil.
+func (m *Map) shortestPath(from, to Location, passable func(Edge) bool) ([]Location, int, error) {
+	if !m.HasLocation(from) {
+		return nil, 0, fmt.Errorf("%s is not a valid location", from)
+	}
+	if !m.HasLocation(to) {
+		return nil, 0, fmt.Errorf("%s is not a valid location", to)
+	}
+
+	dist := map[Location]int{from: 0}
+	prev := map[Location]Location{}
+	done := map[Location]bool{}
+	for {
+		var cur Location
+		found := false
+		for loc, d := range dist {
+			if done[loc] {
+				continue
+			}
+			if !found || d < dist[cur] || (d == dist[cur] && loc < cur) {
+				cur = loc
+				found = true
+			}
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("there is no route from %s to %s", from, to)
+		}
+		if cur == to {
+			break
+		}
+		done[cur] = true
+		for _, n := range m.Neighbors(cur) {
+			e := m.adjacency[cur][n]
+			if passable != nil && !passable(e) {
+				continue
+			}
+			d := dist[cur] + e.TravelTime
+			if old, ok := dist[n]; !ok || d < old {
+				dist[n] = d
+				prev[n] = cur
+			}
+		}
+	}
+
+	path := []Location{to}
+	for path[0] != from {
+		path = append([]Location{prev[path[0]]}, path...)
+	}
+	return path, dist[to], nil
+}
+
+// Hops counts the fewest moves needed to get from one location to another,
+// ignoring travel time. It returns -1 when there is no route.
+func (m *Map) Hops(from, to Location) int {
+	if !m.HasLocation(from) || !m.HasLocation(to) {
+		return -1
+	}
+	hops := map[Location]int{from: 0}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == to {
+			return hops[cur]
+		}
+		for _, n := range m.Neighbors(cur) {
+			if _, ok := hops[n]; !ok {
+				hops[n] = hops[cur] + 1
+				queue = append(queue, n)
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+package gamelogic
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestShortestPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		from       Location
+		to         Location
+		wantPath   []Location
+		wantTravel int
+		wantErr    bool
+	}{
+		{name: "neighbours", from: "europe", to: "asia", wantPath: []Location{"europe", "asia"}, wantTravel: 2},
+		{name: "over ice", from: "americas", to: "australia", wantPath: []Location{"americas", "asia", "australia"}, wantTravel: 4},
+		{name: "quicker than the direct edge", from: "europe", to: "antarctica", wantPath: []Location{"europe", "africa", "antarctica"}, wantTravel: 5},
+		{name: "staying put", from: "europe", to: "europe", wantPath: []Location{"europe"}, wantTravel: 0},
+		{name: "unknown destination", from: "europe", to: "atlantis", wantErr: true},
+		{name: "unknown start", from: "atlantis", to: "europe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, travel, err := DefaultMap().ShortestPath(tt.from, tt.to)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(path, tt.wantPath) || travel != tt.wantTravel {
+				t.Errorf("got %v (%d), want %v (%d)", path, travel, tt.wantPath, tt.wantTravel)
+			}
+		})
+	}
+}
+
+func TestRouteAvoidsImpassableTerrain(t *testing.T) {
+	r := DefaultRuleset()
+	tests := []struct {
+		name       string
+		rank       UnitRank
+		wantPath   []Location
+		wantTravel int
+	}{
+		{name: "infantry crosses the ice", rank: RankInfantry, wantPath: []Location{"americas", "asia"}, wantTravel: 2},
+		{name: "artillery goes around it", rank: RankArtillery, wantPath: []Location{"americas", "europe", "asia"}, wantTravel: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, travel, err := r.Route(DefaultMap(), r.NewUnit(1, tt.rank, "americas"), "asia")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(path, tt.wantPath) || travel != tt.wantTravel {
+				t.Errorf("got %v (%d), want %v (%d)", path, travel, tt.wantPath, tt.wantTravel)
+			}
+		})
+	}
+}
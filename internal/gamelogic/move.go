@@ -3,66 +3,62 @@ package gamelogic
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 )
 
-type MoveOutcome int
-
-const (
-	MoveOutcomeSamePlayer MoveOutcome = iota
-	MoveOutComeSafe
-	MoveOutcomeMakeWar
-)
-
-func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
-	defer fmt.Println("------------------------")
-	player := gs.GetPlayerSnap()
-
-	fmt.Println()
-	fmt.Println("==== Move Detected ====")
-	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
-	for _, unit := range move.Units {
-		fmt.Printf("* %v\n", unit.Rank)
-	}
-
-	if player.Username == move.Player.Username {
-		return MoveOutcomeSamePlayer
-	}
-
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
-		return MoveOutcomeMakeWar
+// getOverlappingLocations returns every location both players have units in,
+// sorted so battles are always fought in the same order.
+func getOverlappingLocations(p1 Player, p2 Player) []Location {
+	held := map[Location]bool{}
+	for _, u := range p1.Units {
+		held[u.Location] = true
 	}
-	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
-	return MoveOutComeSafe
-}
-
-func getOverlappingLocation(p1 Player, p2 Player) Location {
-	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
+	overlapping := map[Location]bool{}
+	for _, u := range p2.Units {
+		if held[u.Location] {
+			overlapping[u.Location] = true
 		}
 	}
-	return ""
+	locs := []Location{}
+	for loc := range overlapping {
+		locs = append(locs, loc)
+	}
+	slices.Sort(locs)
+	return locs
 }
 
 func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+	if gs.isOver() {
+		return ArmyMove{}, errGameOver
+	}
 	if gs.isPaused() {
 		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc [via <location> <location> etc]")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
+	if !gs.Map.HasLocation(newLocation) {
 		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
+	ids := words[2:]
+	via := []Location{}
+	if i := slices.Index(ids, "via"); i >= 0 {
+		for _, word := range ids[i+1:] {
+			loc := Location(word)
+			if !gs.Map.HasLocation(loc) {
+				return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", loc)
+			}
+			via = append(via, loc)
+		}
+		if len(via) == 0 {
+			return ArmyMove{}, errors.New("error: via needs at least one location")
+		}
+		ids = ids[:i]
+	}
 	unitIDs := []int{}
-	for _, word := range words[2:] {
+	for _, word := range ids {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
@@ -70,21 +66,69 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		}
 		unitIDs = append(unitIDs, unitID)
 	}
+	if len(unitIDs) == 0 {
+		return ArmyMove{}, errors.New("error: no units to move")
+	}
 
+	// The move is only an order: the units stay put until the server
+	// resolves the turn and sends back where they ended up.
+	rules := gs.GetRules()
+	ordered := []Unit{}
 	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
-		unit.Location = newLocation
-		gs.UpdateUnit(unit)
+		err := rules.CheckMove(gs.Map, unit, newLocation, via)
+		if err != nil {
+			return ArmyMove{}, fmt.Errorf("error: unit %v can not move to %s in one turn: %v, see: route %s %s %s", unitID, newLocation, err, unit.Location, newLocation, unit.Rank)
+		}
+		ordered = append(ordered, Unit{ID: unitID})
 	}
 
 	mv := ArmyMove{
 		ToLocation: newLocation,
-		Units:      gs.getUnitsSnap(),
-		Player:     gs.GetPlayerSnap(),
+		Units:      ordered,
+		Player:     Player{Username: gs.GetUsername()},
+		Via:        via,
 	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
+	fmt.Printf("Ordered %v units to move to %s at the end of the turn\n", len(mv.Units), mv.ToLocation)
 	return mv, nil
 }
+
+// CommandRoute prints the quickest multi-hop route between two locations, or
+// the quickest one a rank can take and whether it gets there in one turn.
+func (gs *GameState) CommandRoute(words []string) error {
+	if len(words) < 3 {
+		return errors.New("usage: route <from> <to> [rank]")
+	}
+	from, to := Location(words[1]), Location(words[2])
+	var path []Location
+	var travelTime int
+	var err error
+	if len(words) > 3 {
+		rules := gs.GetRules()
+		rr, ok := rules.Rank(UnitRank(words[3]))
+		if !ok {
+			return fmt.Errorf("error: %s is not a valid unit", words[3])
+		}
+		if !gs.Map.HasLocation(from) {
+			return fmt.Errorf("error: %s is not a valid location", from)
+		}
+		path, travelTime, err = rules.Route(gs.Map, Unit{Rank: rr.Rank, Location: from}, to)
+		if err == nil {
+			defer fmt.Printf("A(n) %s can travel %d in a turn.\n", rr.Rank, rr.Speed)
+		}
+	} else {
+		path, travelTime, err = gs.Map.ShortestPath(from, to)
+	}
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	fmt.Printf("Route from %s to %s (%d move(s), travel time %d):\n", path[0], path[len(path)-1], len(path)-1, travelTime)
+	for i := 1; i < len(path); i++ {
+		e, _ := gs.Map.Edge(path[i-1], path[i])
+		fmt.Printf("* %s -> %s by %s (%d)\n", e.From, e.To, e.Terrain, e.TravelTime)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	PresenceJoin      = "join"
+	PresenceHeartbeat = "heartbeat"
+	PresenceLeave     = "leave"
+)
+
+const (
+	StatusConnected    = "connected"
+	StatusDisconnected = "disconnected"
+	StatusLeft         = "left"
+)
+
+// HeartbeatInterval is how often clients say they are still playing.
+const HeartbeatInterval = 5 * time.Second
+
+// MissedHeartbeats is how many heartbeats in a row a player can miss before
+// they are considered disconnected.
+const MissedHeartbeats = 3
+
+// Presence is a client saying it joined, is still there, or is leaving.
+type Presence struct {
+	Username string
+	Kind     string
+	SentAt   time.Time
+}
+
+func (p Presence) Claimant() string {
+	return p.Username
+}
+
+// PlayerPresence is what the server knows about whether a player is
+// connected.
+type PlayerPresence struct {
+	Username string
+	Status   string
+	JoinedAt time.Time
+	LastSeen time.Time
+}
+
+// Roster tracks which players of a game are connected. Times are the
+// server's, so clients with a wrong clock are not dropped early.
+type Roster struct {
+	players map[string]PlayerPresence
+	timeout time.Duration
+	mu      *sync.RWMutex
+}
+
+func NewRoster() *Roster {
+	return &Roster{
+		players: map[string]PlayerPresence{},
+		timeout: MissedHeartbeats * HeartbeatInterval,
+		mu:      &sync.RWMutex{},
+	}
+}
+
+// Record applies a presence message received at now. changed is true when it
+// made the player's status change, for example when a heartbeat comes from a
+// player who had been dropped.
+func (r *Roster) Record(p Presence, now time.Time) (pp PlayerPresence, changed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pp, ok := r.players[p.Username]
+	if !ok || pp.Status == StatusLeft {
+		pp = PlayerPresence{
+			Username: p.Username,
+			JoinedAt: now,
+		}
+	}
+	previous := pp.Status
+	pp.LastSeen = now
+	pp.Status = StatusConnected
+	if p.Kind == PresenceLeave {
+		pp.Status = StatusLeft
+	}
+	r.players[p.Username] = pp
+	return pp, pp.Status != previous
+}
+
+// Sweep marks every connected player who has not been heard from in too long
+// as disconnected, and returns them.
+func (r *Roster) Sweep(now time.Time) []PlayerPresence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dropped := []PlayerPresence{}
+	for name, pp := range r.players {
+		if pp.Status != StatusConnected || now.Sub(pp.LastSeen) <= r.timeout {
+			continue
+		}
+		pp.Status = StatusDisconnected
+		r.players[name] = pp
+		dropped = append(dropped, pp)
+	}
+	return dropped
+}
+
+// Snapshot returns every player the roster has heard from, ordered by name.
+func (r *Roster) Snapshot() []PlayerPresence {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	players := []PlayerPresence{}
+	for _, pp := range r.players {
+		players = append(players, pp)
+	}
+	slices.SortFunc(players, func(a, b PlayerPresence) int {
+		if a.Username < b.Username {
+			return -1
+		}
+		if a.Username > b.Username {
+			return 1
+		}
+		return 0
+	})
+	return players
+}
+
+// PrintRoster lists who is playing, for the server's players command.
+func PrintRoster(players []PlayerPresence, now time.Time) {
+	if len(players) == 0 {
+		fmt.Println("nobody has joined yet")
+		return
+	}
+	for _, pp := range players {
+		fmt.Printf("* %s: %s, last seen %v ago\n", pp.Username, pp.Status, now.Sub(pp.LastSeen).Round(time.Second))
+	}
+}
+
+// HandlePresenceUpdate tells the player that someone else joined, dropped or
+// left the game.
+func (gs *GameState) HandlePresenceUpdate(pp PlayerPresence) {
+	if pp.Username == gs.GetUsername() {
+		return
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	switch pp.Status {
+	case StatusConnected:
+		fmt.Println("==== Player Connected ====")
+		fmt.Printf("%s is playing.\n", pp.Username)
+	case StatusDisconnected:
+		fmt.Println("==== Player Disconnected ====")
+		fmt.Printf("%s has not been heard from since %v.\n", pp.Username, pp.LastSeen.Format(time.Kitchen))
+	case StatusLeft:
+		fmt.Println("==== Player Left ====")
+		fmt.Printf("%s has left.\n", pp.Username)
+	}
+}
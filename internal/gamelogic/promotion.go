@@ -0,0 +1,142 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// PromotionOrder asks the server to promote one of a player's units to the
+// next rank when the turn ends.
+type PromotionOrder struct {
+	Username string
+	UnitID   int
+}
+
+func (po PromotionOrder) Claimant() string {
+	return po.Username
+}
+
+// promotion is a queued PromotionOrder and what was reserved to pay for it.
+type promotion struct {
+	order PromotionOrder
+	cost  int
+}
+
+// checkPromotion reports whether u can be promoted, and returns the rank it
+// would be promoted from.
+func (r *Ruleset) checkPromotion(u Unit) (RankRule, error) {
+	rr, ok := r.Rank(u.Rank)
+	if !ok {
+		return RankRule{}, fmt.Errorf("%s is not a valid unit", u.Rank)
+	}
+	if rr.PromotesTo == "" {
+		return RankRule{}, fmt.Errorf("a(n) %s can not be promoted", u.Rank)
+	}
+	if u.XP < rr.PromotionXP {
+		return RankRule{}, fmt.Errorf("unit %v needs %d experience to be promoted but only has %d", u.ID, rr.PromotionXP, u.XP)
+	}
+	return rr, nil
+}
+
+// Promote turns u into the next rank at full health. The experience needed
+// for the promotion is used up.
+func (r *Ruleset) Promote(u Unit) Unit {
+	rr, _ := r.Rank(u.Rank)
+	promoted := r.NewUnit(u.ID, rr.PromotesTo, u.Location)
+	promoted.XP = u.XP - rr.PromotionXP
+	return promoted
+}
+
+// QueuePromotion checks a promotion against the current army and holds it
+// until the turn is resolved.
+func (w *World) QueuePromotion(po PromotionOrder) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return errGameOver
+	}
+	if w.Paused {
+		return errors.New("the game is paused, you can not promote units")
+	}
+	p, err := w.lookup(po.Username)
+	if err != nil {
+		return err
+	}
+	u, ok := p.Units[po.UnitID]
+	if !ok {
+		return fmt.Errorf("unit with ID %v not found", po.UnitID)
+	}
+	for _, queued := range w.promotions {
+		if queued.order.Username == po.Username && queued.order.UnitID == po.UnitID {
+			return fmt.Errorf("unit %v is already being promoted", po.UnitID)
+		}
+	}
+	rr, err := w.Rules.checkPromotion(u)
+	if err != nil {
+		return err
+	}
+	if w.Treasury[po.Username] < rr.PromotionCost {
+		return fmt.Errorf("promoting a(n) %s costs %d but you only have %d", u.Rank, rr.PromotionCost, w.Treasury[po.Username])
+	}
+	w.Treasury[po.Username] -= rr.PromotionCost
+	w.promotions = append(w.promotions, promotion{order: po, cost: rr.PromotionCost})
+	return nil
+}
+
+// applyPromotions promotes every unit that is still eligible, refunding the
+// ones that no longer are.
+func (w *World) applyPromotions(r *resolution) {
+	for _, queued := range w.promotions {
+		po := queued.order
+		p := w.player(po.Username)
+		u, ok := p.Units[po.UnitID]
+		if !ok {
+			w.Treasury[po.Username] += queued.cost
+			r.reject(po.Username, fmt.Errorf("unit with ID %v no longer exists", po.UnitID))
+			continue
+		}
+		_, err := w.Rules.checkPromotion(u)
+		if err != nil {
+			w.Treasury[po.Username] += queued.cost
+			r.reject(po.Username, err)
+			continue
+		}
+		promoted := w.Rules.Promote(u)
+		p.Units[u.ID] = promoted
+		sd := r.deltaFor(po.Username)
+		sd.Upserted = append(sd.Upserted, promoted)
+	}
+}
+
+// CommandPromote parses "promote <unitID>".
+func (gs *GameState) CommandPromote(words []string) (PromotionOrder, error) {
+	if gs.isOver() {
+		return PromotionOrder{}, errGameOver
+	}
+	if len(words) < 2 {
+		return PromotionOrder{}, errors.New("usage: promote <unitID>")
+	}
+	id, err := strconv.Atoi(words[1])
+	if err != nil {
+		return PromotionOrder{}, fmt.Errorf("error: %s is not a valid unit ID", words[1])
+	}
+	u, ok := gs.GetUnit(id)
+	if !ok {
+		return PromotionOrder{}, fmt.Errorf("error: unit with ID %v not found", id)
+	}
+	rules := gs.GetRules()
+	rr, err := rules.checkPromotion(u)
+	if err != nil {
+		return PromotionOrder{}, fmt.Errorf("error: %v", err)
+	}
+	err = gs.spend(rr.PromotionCost)
+	if err != nil {
+		return PromotionOrder{}, fmt.Errorf("error: %v", err)
+	}
+	fmt.Printf("Ordered your %s %v to be promoted to %s at the end of the turn\n", u.Rank, u.ID, rr.PromotesTo)
+	return PromotionOrder{
+		Username: gs.GetUsername(),
+		UnitID:   id,
+	}, nil
+}
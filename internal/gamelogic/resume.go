@@ -0,0 +1,162 @@
+package gamelogic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ResumeHistory is how many turns a player can miss and still be told what
+// happened in each of them when they come back.
+const ResumeHistory = 20
+
+const saveKindSession = "session"
+
+// Session is handed to a player when they join a game. Presenting its token
+// again lets them pick up where they left off after their client crashed.
+type Session struct {
+	GameID   string
+	Username string
+	Token    string
+}
+
+// PlayerSnapshot is everything a returning player needs from the server:
+// their canonical army and where the game is now, along with what they could
+// see of the turns resolved while they were away, oldest first.
+type PlayerSnapshot struct {
+	GameID   string
+	Army     StateDelta
+	Turn     int
+	Seed     int64
+	Owners   map[Location]string
+	Treaties []Treaty
+	Paused   bool
+	Rules    Ruleset
+	GameOver *GameOver
+	Missed   []TurnResult
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SessionPath is where the client keeps username's latest session.
+func SessionPath(username string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "peril", "sessions", username+".session"), nil
+}
+
+func SaveSession(s Session) error {
+	path, err := SessionPath(s.Username)
+	if err != nil {
+		return err
+	}
+	return writeSave(path, saveKindSession, s)
+}
+
+func LoadSession(username string) (Session, error) {
+	path, err := SessionPath(username)
+	if err != nil {
+		return Session{}, err
+	}
+	s := Session{}
+	err = readSave(path, saveKindSession, &s)
+	if err != nil {
+		return Session{}, err
+	}
+	if s.Username != username {
+		return Session{}, fmt.Errorf("session belongs to %s", s.Username)
+	}
+	return s, nil
+}
+
+// KeepView holds on to a turn's result as one player saw it, so it can be
+// shown to them if they were not there to see it themselves.
+func (w *World) KeepView(view TurnResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	views := append(w.views[view.Viewer], view)
+	if len(views) > ResumeHistory {
+		views = views[len(views)-ResumeHistory:]
+	}
+	w.views[view.Viewer] = views
+}
+
+// Snapshot builds what username needs to resume playing. Missed starts at
+// turn since, the turn their client was on when it lost touch.
+func (w *World) Snapshot(username string, since int) PlayerSnapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	snap := PlayerSnapshot{
+		GameID:   w.ID,
+		Army:     w.syncDelta(username),
+		Turn:     w.Turn,
+		Seed:     w.Seed,
+		Owners:   map[Location]string{},
+		Paused:   w.Paused,
+		Rules:    *w.Rules,
+		GameOver: w.GameOver,
+	}
+	copyMap(snap.Owners, w.Owners)
+	for _, t := range w.Treaties {
+		if t.Players[0] == username || t.Players[1] == username {
+			snap.Treaties = append(snap.Treaties, t)
+		}
+	}
+	for _, view := range w.views[username] {
+		if view.Turn >= since {
+			snap.Missed = append(snap.Missed, view)
+		}
+	}
+	return snap
+}
+
+// Resume catches the player up on the turns they missed, then replaces
+// their state with the server's.
+func (gs *GameState) Resume(snap PlayerSnapshot) error {
+	err := gs.HandleRuleset(snap.Rules)
+	if err != nil {
+		return err
+	}
+	for _, tr := range snap.Missed {
+		gs.HandleTurnResult(tr)
+	}
+	gs.HandleStateDelta(snap.Army)
+
+	gs.mu.Lock()
+	gs.record(Event{Kind: EventTurnStarted, Turn: snap.Turn, Seed: snap.Seed, Owners: snap.Owners})
+	if snap.Paused {
+		gs.record(Event{Kind: EventGamePaused})
+	} else {
+		gs.record(Event{Kind: EventGameResumed})
+	}
+	for _, t := range snap.Treaties {
+		gs.Treaties[treatyKey(t.Players[0], t.Players[1])] = t
+	}
+	if snap.GameOver != nil {
+		gs.record(Event{Kind: EventGameOver, GameOver: snap.GameOver})
+	}
+	gs.mu.Unlock()
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Caught Up ====")
+	fmt.Printf("You missed %d turn(s). It is now turn %d.\n", len(snap.Missed), snap.Turn)
+	if snap.Paused {
+		fmt.Println("The game is paused.")
+	}
+	if snap.GameOver != nil {
+		fmt.Printf("The game is over: %v %s.\n", snap.GameOver.Winners, snap.GameOver.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,437 @@
+package gamelogic
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// RankRule describes one unit rank: how much it contributes to a battle, how
+// much damage it can take, what it costs to spawn and to keep each turn, how
+// much travel time it can cover in a turn and which terrain it can cross (any
+// when Terrains is empty). A unit with PromotionXP experience can be promoted
+// to PromotesTo for PromotionCost.
+type RankRule struct {
+	Rank          UnitRank
+	Power         int
+	Health        int
+	Cost          int
+	Upkeep        int
+	Speed         int
+	Terrains      []Terrain
+	PromotesTo    UnitRank
+	PromotionCost int
+	PromotionXP   int
+}
+
+// SpawnLimits caps army sizes. Zero means no limit.
+type SpawnLimits struct {
+	MaxUnits            int
+	MaxUnitsPerLocation int
+}
+
+// Economy sets how players earn money. Every player starts with
+// StartingFunds and earns IncomePerLocation for each location only they hold
+// when a turn ends.
+type Economy struct {
+	StartingFunds     int
+	IncomePerLocation int
+}
+
+// Veterancy sets how units grow with experience. Every unit that survives a
+// battle earns XPPerBattle, and every LevelXP of experience, up to MaxLevel
+// levels, adds BonusPerLevel to its power.
+type Veterancy struct {
+	XPPerBattle   int
+	LevelXP       int
+	BonusPerLevel int
+	MaxLevel      int
+}
+
+const (
+	WinHoldLocations = "hold_locations"
+	WinEliminate     = "eliminate"
+	WinHighestScore  = "highest_score"
+)
+
+// WinCondition ends the game. For WinHoldLocations a player has to hold Count
+// locations for Ticks turns; for WinHighestScore the game ends after Ticks
+// turns.
+type WinCondition struct {
+	Kind  string
+	Count int
+	Ticks int
+}
+
+// RulesetFormat is the shape of a ruleset file this build reads. Version is
+// the ruleset's own revision, Format the revision of the fields it is written
+// in, which every file has to give.
+const RulesetFormat = 1
+
+// Ruleset is the data every participant has to agree on. The server loads one
+// at startup and broadcasts it, and clients replace their own with it. Combat
+// picks the CombatResolver and defaults to CombatPower.
+type Ruleset struct {
+	Name          string
+	Version       int
+	Format        int
+	Ranks         []RankRule
+	Veterancy     Veterancy
+	Locations     []Location
+	SpawnLimits   SpawnLimits
+	Economy       Economy
+	WinConditions []WinCondition
+	Combat        string
+}
+
+//go:embed data/ruleset.json
+var defaultRulesetData []byte
+
+var defaultRuleset = mustParseRuleset(defaultRulesetData)
+
+func DefaultRuleset() *Ruleset {
+	return defaultRuleset
+}
+
+func LoadRuleset(path string, m *Map) (*Ruleset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ruleset file: %v", err)
+	}
+	r, err := ParseRuleset(data)
+	if err != nil {
+		return nil, err
+	}
+	err = r.Validate(m)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func ParseRuleset(data []byte) (*Ruleset, error) {
+	r := &Ruleset{}
+	err := json.Unmarshal(data, r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ruleset: %v", err)
+	}
+	err = r.checkFormat()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkFormat refuses a ruleset that does not say which format it is written
+// in, or is written in one this build does not read.
+func (r *Ruleset) checkFormat() error {
+	if r.Format == 0 {
+		return errors.New("ruleset needs a format")
+	}
+	if r.Format != RulesetFormat {
+		return fmt.Errorf("ruleset format %d is not supported, this build reads format %d", r.Format, RulesetFormat)
+	}
+	return nil
+}
+
+func mustParseRuleset(data []byte) *Ruleset {
+	r, err := ParseRuleset(data)
+	if err != nil {
+		panic(err)
+	}
+	err = r.Validate(DefaultMap())
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Validate reports every problem with the ruleset at once, checking its
+// locations against the map it will be played on.
+func (r *Ruleset) Validate(m *Map) error {
+	errs := []error{}
+	if r.Name == "" {
+		errs = append(errs, errors.New("ruleset needs a name"))
+	}
+	if len(r.Ranks) == 0 {
+		errs = append(errs, errors.New("ruleset needs at least one rank"))
+	}
+	ranks := map[UnitRank]struct{}{}
+	for _, rr := range r.Ranks {
+		if rr.Rank == "" {
+			errs = append(errs, errors.New("rank needs a name"))
+			continue
+		}
+		if _, ok := ranks[rr.Rank]; ok {
+			errs = append(errs, fmt.Errorf("rank %s is listed twice", rr.Rank))
+		}
+		ranks[rr.Rank] = struct{}{}
+		if rr.Power <= 0 {
+			errs = append(errs, fmt.Errorf("rank %s needs a positive power", rr.Rank))
+		}
+		if rr.Cost < 0 {
+			errs = append(errs, fmt.Errorf("rank %s can not have a negative cost", rr.Rank))
+		}
+		if rr.Upkeep < 0 {
+			errs = append(errs, fmt.Errorf("rank %s can not have a negative upkeep", rr.Rank))
+		}
+		if rr.Health <= 0 {
+			errs = append(errs, fmt.Errorf("rank %s needs a positive health", rr.Rank))
+		}
+		if rr.Speed <= 0 {
+			errs = append(errs, fmt.Errorf("rank %s needs a positive speed", rr.Rank))
+		}
+		for _, t := range rr.Terrains {
+			if !m.HasTerrain(t) {
+				errs = append(errs, fmt.Errorf("rank %s can cross %s, which is not on the map", rr.Rank, t))
+			}
+		}
+		if rr.PromotionCost < 0 || rr.PromotionXP < 0 {
+			errs = append(errs, fmt.Errorf("rank %s can not have a negative promotion cost", rr.Rank))
+		}
+	}
+	for _, rr := range r.Ranks {
+		if rr.PromotesTo == "" {
+			continue
+		}
+		if _, ok := ranks[rr.PromotesTo]; !ok || rr.PromotesTo == rr.Rank {
+			errs = append(errs, fmt.Errorf("rank %s can not be promoted to %s", rr.Rank, rr.PromotesTo))
+		}
+	}
+	v := r.Veterancy
+	if v.XPPerBattle < 0 || v.LevelXP < 0 || v.BonusPerLevel < 0 || v.MaxLevel < 0 {
+		errs = append(errs, errors.New("veterancy can not be negative"))
+	}
+	if len(r.Locations) == 0 {
+		errs = append(errs, errors.New("ruleset needs at least one location"))
+	}
+	for _, loc := range r.Locations {
+		if !m.HasLocation(loc) {
+			errs = append(errs, fmt.Errorf("location %s is not on the map", loc))
+		}
+	}
+	if r.SpawnLimits.MaxUnits < 0 || r.SpawnLimits.MaxUnitsPerLocation < 0 {
+		errs = append(errs, errors.New("spawn limits can not be negative"))
+	}
+	if r.Economy.StartingFunds < 0 || r.Economy.IncomePerLocation < 0 {
+		errs = append(errs, errors.New("economy can not be negative"))
+	}
+	for _, wc := range r.WinConditions {
+		switch wc.Kind {
+		case WinHoldLocations:
+			if wc.Count <= 0 || wc.Count > len(r.Locations) {
+				errs = append(errs, fmt.Errorf("%s needs a count between 1 and %d", wc.Kind, len(r.Locations)))
+			}
+			if wc.Ticks <= 0 {
+				errs = append(errs, fmt.Errorf("%s needs a positive number of ticks", wc.Kind))
+			}
+		case WinEliminate:
+		case WinHighestScore:
+			if wc.Ticks <= 0 {
+				errs = append(errs, fmt.Errorf("%s needs a positive number of ticks", wc.Kind))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown win condition: %q", wc.Kind))
+		}
+	}
+	switch r.Combat {
+	case "", CombatPower, CombatDice:
+	default:
+		errs = append(errs, fmt.Errorf("unknown combat resolver: %q", r.Combat))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid ruleset: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (r *Ruleset) Rank(rank UnitRank) (RankRule, bool) {
+	for _, rr := range r.Ranks {
+		if rr.Rank == rank {
+			return rr, true
+		}
+	}
+	return RankRule{}, false
+}
+
+func (r *Ruleset) AllowsLocation(loc Location) bool {
+	for _, l := range r.Locations {
+		if l == loc {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSpawn enforces the spawn limits for a new unit joining units.
+func (r *Ruleset) CheckSpawn(units map[int]Unit, loc Location) error {
+	if r.SpawnLimits.MaxUnits > 0 && len(units) >= r.SpawnLimits.MaxUnits {
+		return fmt.Errorf("you already have the maximum of %d units", r.SpawnLimits.MaxUnits)
+	}
+	if r.SpawnLimits.MaxUnitsPerLocation > 0 && len(unitsInLocation(Player{Units: units}, loc)) >= r.SpawnLimits.MaxUnitsPerLocation {
+		return fmt.Errorf("%s already holds the maximum of %d of your units", loc, r.SpawnLimits.MaxUnitsPerLocation)
+	}
+	return nil
+}
+
+// Upkeep is what it costs to keep units for a turn.
+func (r *Ruleset) Upkeep(units []Unit) int {
+	upkeep := 0
+	for _, unit := range units {
+		rr, _ := r.Rank(unit.Rank)
+		upkeep += rr.Upkeep
+	}
+	return upkeep
+}
+
+func (r *Ruleset) PowerLevel(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += r.UnitPower(unit)
+	}
+	return power
+}
+
+// UnitPower is what a unit brings to a battle: its rank's power scaled down by
+// its wounds, but never below 1, plus a bonus for every veterancy level.
+func (r *Ruleset) UnitPower(u Unit) int {
+	u = r.UpgradeUnit(u)
+	rr, ok := r.Rank(u.Rank)
+	if !ok {
+		return 0
+	}
+	power := max(rr.Power*u.Health/rr.Health, 1)
+	return power + r.Level(u)*r.Veterancy.BonusPerLevel
+}
+
+// Level is how many veterancy levels a unit has earned.
+func (r *Ruleset) Level(u Unit) int {
+	if r.Veterancy.LevelXP <= 0 {
+		return 0
+	}
+	level := u.XP / r.Veterancy.LevelXP
+	if r.Veterancy.MaxLevel > 0 {
+		level = min(level, r.Veterancy.MaxLevel)
+	}
+	return level
+}
+
+// NewUnit is a fresh unit of rank at full health.
+func (r *Ruleset) NewUnit(id int, rank UnitRank, loc Location) Unit {
+	rr, _ := r.Rank(rank)
+	return Unit{
+		Version:  UnitVersion,
+		ID:       id,
+		Rank:     rank,
+		Location: loc,
+		Health:   rr.Health,
+	}
+}
+
+// UpgradeUnit brings a unit from an older wire format up to date. Units from
+// before health existed are at full health with no experience.
+func (r *Ruleset) UpgradeUnit(u Unit) Unit {
+	if u.Version >= UnitVersion {
+		return u
+	}
+	rr, _ := r.Rank(u.Rank)
+	u.Version = UnitVersion
+	u.Health = rr.Health
+	u.XP = 0
+	return u
+}
+
+// Resolver returns the CombatResolver the ruleset plays with. seed is only
+// used by resolvers that roll dice.
+func (r *Ruleset) Resolver(seed int64) CombatResolver {
+	if r.Combat == CombatDice {
+		return DiceResolver{
+			Rules:  r,
+			Seed:   seed,
+			Rounds: DefaultDiceRounds,
+		}
+	}
+	return PowerResolver{Rules: r}
+}
+
+// HandleRuleset adopts the ruleset broadcast by the server.
+func (gs *GameState) HandleRuleset(r Ruleset) error {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Ruleset Received ====")
+	err := r.checkFormat()
+	if err == nil {
+		err = r.Validate(gs.Map)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.record(Event{Kind: EventRulesAdopted, Rules: &r})
+	fmt.Printf("Playing by the %s ruleset (version %d).\n", r.Name, r.Version)
+	if r.Combat == CombatDice {
+		fmt.Println("Battles are fought with dice.")
+	}
+	return nil
+}
+
+// Passable reports whether units of the rank can cross an edge.
+func (rr RankRule) Passable(e Edge) bool {
+	return len(rr.Terrains) == 0 || slices.Contains(rr.Terrains, e.Terrain)
+}
+
+// Route finds the quickest route a unit can take to loc over terrain its rank
+// can cross, returning every location along the way (both ends included) and
+// the total travel time.
+func (r *Ruleset) Route(m *Map, unit Unit, loc Location) ([]Location, int, error) {
+	rr, ok := r.Rank(unit.Rank)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s is not a valid unit", unit.Rank)
+	}
+	return m.shortestPath(unit.Location, loc, rr.Passable)
+}
+
+// CheckMove reports why a unit can not get to loc within a single turn. A
+// unit only moves to a neighbouring location unless it is given the route to
+// take, passing through via in order. Every edge costs its travel time, and a
+// unit can cover its rank's speed in a turn.
+func (r *Ruleset) CheckMove(m *Map, unit Unit, loc Location, via []Location) error {
+	rr, ok := r.Rank(unit.Rank)
+	if !ok {
+		return fmt.Errorf("%s is not a valid unit", unit.Rank)
+	}
+	if unit.Location == loc && len(via) == 0 {
+		return nil
+	}
+	path := append([]Location{unit.Location}, via...)
+	path = append(path, loc)
+	travelTime := 0
+	for i := 1; i < len(path); i++ {
+		e, ok := m.Edge(path[i-1], path[i])
+		if !ok && len(via) == 0 {
+			return fmt.Errorf("%s is not next to %s, give the route to take with via", loc, unit.Location)
+		}
+		if !ok {
+			return fmt.Errorf("%s is not next to %s", path[i], path[i-1])
+		}
+		if !rr.Passable(e) {
+			return fmt.Errorf("a(n) %s can not cross %s from %s to %s", unit.Rank, e.Terrain, e.From, e.To)
+		}
+		travelTime += e.TravelTime
+	}
+	if travelTime > rr.Speed {
+		return fmt.Errorf("the route takes %d but a(n) %s can only travel %d in a turn", travelTime, unit.Rank, rr.Speed)
+	}
+	return nil
+}
+
+// CanReach reports whether a unit can move straight to loc, a neighbouring
+// location, within a single turn.
+func (r *Ruleset) CanReach(m *Map, unit Unit, loc Location) bool {
+	return r.CheckMove(m, unit, loc, nil) == nil
+}
@@ -0,0 +1,35 @@
+package gamelogic
+
+import "testing"
+
+func TestCheckMove(t *testing.T) {
+	r := DefaultRuleset()
+	tests := []struct {
+		name    string
+		rank    UnitRank
+		from    Location
+		to      Location
+		via     []Location
+		wantErr bool
+	}{
+		{name: "to a neighbour", rank: RankInfantry, from: "europe", to: "asia"},
+		{name: "staying put", rank: RankInfantry, from: "europe", to: "europe"},
+		{name: "not a neighbour", rank: RankInfantry, from: "europe", to: "australia", wantErr: true},
+		{name: "not a neighbour with a route", rank: RankInfantry, from: "europe", to: "australia", via: []Location{"asia"}},
+		{name: "route too long for infantry", rank: RankInfantry, from: "americas", to: "asia", via: []Location{"europe"}, wantErr: true},
+		{name: "route in reach of cavalry", rank: RankCavalry, from: "americas", to: "asia", via: []Location{"europe"}},
+		{name: "route that skips a location", rank: RankInfantry, from: "europe", to: "asia", via: []Location{"australia"}, wantErr: true},
+		{name: "infantry over ice", rank: RankInfantry, from: "americas", to: "asia"},
+		{name: "artillery over ice", rank: RankArtillery, from: "americas", to: "asia", wantErr: true},
+		{name: "unknown rank", rank: "dragon", from: "europe", to: "asia", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit := Unit{ID: 1, Rank: tt.rank, Location: tt.from, Health: 1}
+			err := r.CheckMove(DefaultMap(), unit, tt.to, tt.via)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
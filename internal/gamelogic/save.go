@@ -0,0 +1,399 @@
+package gamelogic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// SaveFormat is the current revision of the save file format.
+const SaveFormat = 1
+
+const (
+	saveKindClient = "client"
+	saveKindWorld  = "world"
+)
+
+// saveFile is what is written to disk. Data is checksummed so a truncated or
+// corrupted save is refused instead of being loaded half-way. The checksum is
+// not keyed, so it does not stop anyone from editing a save on purpose.
+type saveFile struct {
+	Format   int
+	Kind     string
+	SavedAt  time.Time
+	Checksum string
+	Data     json.RawMessage
+}
+
+// ClientSave is everything a client needs to pick up where it left off.
+type ClientSave struct {
+	Player   Player
+	Paused   bool
+	Turn     int
+	Seed     int64
+	Treasury int
+	Owners   map[Location]string
+	Treaties map[string]Treaty
+	Inbox    []PrivateMessage
+	UnitIDs  UnitIDAllocator
+	Rules    Ruleset
+	GameOver *GameOver
+}
+
+// WorldSave is the server's whole game. Orders still queued for the current
+// turn are not part of it, so players have to give them again after a load.
+type WorldSave struct {
+	Players  map[string]Player
+	UnitIDs  map[string]UnitIDAllocator
+	Treasury map[string]int
+	Owners   map[Location]string
+	Scores   map[string]int
+	Streaks  map[string][]int
+	Fielded  map[string]bool
+	Treaties map[string]Treaty
+	GameOver *GameOver
+	Paused   bool
+	Turn     int
+	Seed     int64
+	Rules    Ruleset
+}
+
+// SavePath is where username's save called name lives, next to their keys in
+// the user's config directory.
+func SavePath(username, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("%q is not a valid save name", name)
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "peril", "saves", username, name+".save"), nil
+}
+
+func writeSave(path, kind string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not encode save: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	file, err := json.MarshalIndent(saveFile{
+		Format:   SaveFormat,
+		Kind:     kind,
+		SavedAt:  time.Now(),
+		Checksum: hex.EncodeToString(sum[:]),
+		Data:     data,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode save: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("could not create save directory: %v", err)
+	}
+	// Writing next to the save and renaming it over means a crash never
+	// leaves half a save behind.
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, file, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write save: %v", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("could not write save: %v", err)
+	}
+	return nil
+}
+
+func readSave(path, kind string, v any) error {
+	file, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("there is no save called %s", filepath.Base(path))
+	}
+	if err != nil {
+		return fmt.Errorf("could not read save: %v", err)
+	}
+	sf := saveFile{}
+	err = json.Unmarshal(file, &sf)
+	if err != nil {
+		return fmt.Errorf("save is corrupt: %v", err)
+	}
+	if sf.Format < 1 || sf.Format > SaveFormat {
+		return fmt.Errorf("save format %d is not supported by this build (%d)", sf.Format, SaveFormat)
+	}
+	if sf.Kind != kind {
+		return fmt.Errorf("save is a %s save, not a %s save", sf.Kind, kind)
+	}
+	// The checksum is of the compact encoding, since Data was indented along
+	// with the rest of the file.
+	data := &bytes.Buffer{}
+	err = json.Compact(data, sf.Data)
+	if err != nil {
+		return fmt.Errorf("save is corrupt: %v", err)
+	}
+	sum := sha256.Sum256(data.Bytes())
+	if hex.EncodeToString(sum[:]) != sf.Checksum {
+		return errors.New("save is corrupt: checksum does not match")
+	}
+	err = json.Unmarshal(data.Bytes(), v)
+	if err != nil {
+		return fmt.Errorf("save is corrupt: %v", err)
+	}
+	return nil
+}
+
+// loadRules checks a saved ruleset is in a format this build reads and still
+// playable on m.
+func loadRules(r Ruleset, m *Map) (*Ruleset, error) {
+	err := r.checkFormat()
+	if err != nil {
+		return nil, err
+	}
+	err = r.Validate(m)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func upgradePlayer(r *Ruleset, p Player) Player {
+	units := map[int]Unit{}
+	for id, u := range p.Units {
+		units[id] = r.UpgradeUnit(u)
+	}
+	return Player{
+		Username: p.Username,
+		Units:    units,
+	}
+}
+
+// Save writes the client's state to the save called name.
+func (gs *GameState) Save(name string) error {
+	path, err := SavePath(gs.GetUsername(), name)
+	if err != nil {
+		return err
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return writeSave(path, saveKindClient, gs.clientSave())
+}
+
+// clientSave copies everything that is saved. gs.mu must be held.
+func (gs *GameState) clientSave() ClientSave {
+	units := map[int]Unit{}
+	for id, u := range gs.Player.Units {
+		units[id] = u
+	}
+	return ClientSave{
+		Player: Player{
+			Username: gs.Player.Username,
+			Units:    units,
+		},
+		Paused:   gs.Paused,
+		Turn:     gs.Turn,
+		Seed:     gs.Seed,
+		Treasury: gs.Treasury,
+		Owners:   gs.Owners,
+		Treaties: gs.Treaties,
+		Inbox:    gs.Inbox,
+		UnitIDs:  gs.UnitIDs,
+		Rules:    *gs.Rules,
+		GameOver: gs.GameOver,
+	}
+}
+
+// restore replaces the state with a save whose ruleset has already been
+// checked. gs.mu must be held.
+func (gs *GameState) restore(cs ClientSave) {
+	rules := cs.Rules
+	gs.Player = upgradePlayer(&rules, cs.Player)
+	gs.Paused = cs.Paused
+	gs.Turn = cs.Turn
+	gs.Seed = cs.Seed
+	gs.Treasury = cs.Treasury
+	gs.Owners = cs.Owners
+	gs.Treaties = map[string]Treaty{}
+	for key, t := range cs.Treaties {
+		gs.Treaties[key] = t
+	}
+	gs.Inbox = append([]PrivateMessage{}, cs.Inbox...)
+	gs.UnitIDs = cs.UnitIDs
+	gs.Rules = &rules
+	gs.GameOver = cs.GameOver
+	gs.Enemies = nil
+	gs.BattleWarnings = nil
+}
+
+// Load replaces the client's state with the save called name. A save can only
+// be loaded by the player who made it.
+func (gs *GameState) Load(name string) error {
+	path, err := SavePath(gs.GetUsername(), name)
+	if err != nil {
+		return err
+	}
+	cs := ClientSave{}
+	err = readSave(path, saveKindClient, &cs)
+	if err != nil {
+		return err
+	}
+	if cs.Player.Username != gs.GetUsername() {
+		return fmt.Errorf("save belongs to %s", cs.Player.Username)
+	}
+	rules, err := loadRules(cs.Rules, gs.Map)
+	if err != nil {
+		return err
+	}
+
+	cs.Rules = *rules
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.record(Event{Kind: EventGameLoaded, Save: &cs})
+	return nil
+}
+
+// CommandSave parses "save <name>".
+func (gs *GameState) CommandSave(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: save <name>")
+	}
+	err := gs.Save(words[1])
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	fmt.Printf("Saved the game as %s.\n", words[1])
+	return nil
+}
+
+// CommandLoad parses "load <name>". The server's army is still the one that
+// counts, so its next update wins over anything that changed since the save.
+func (gs *GameState) CommandLoad(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: load <name>")
+	}
+	err := gs.Load(words[1])
+	if err != nil {
+		return fmt.Errorf("error: %v", err)
+	}
+	p := gs.GetPlayerSnap()
+	fmt.Printf("Loaded %s: turn %d, %d unit(s), %d in the treasury.\n", words[1], gs.GetTurn(), len(p.Units), gs.GetTreasury())
+	return nil
+}
+
+// Save writes the whole game to the save called name. It can not be saved
+// while a turn is being resolved.
+func (w *World) Save(name string) error {
+	path, err := SavePath(routing.AuthorityUsername, name)
+	if err != nil {
+		return err
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.resolving != nil {
+		return errors.New("can not save while a turn is being resolved")
+	}
+	ids := map[string]UnitIDAllocator{}
+	for name, a := range w.UnitIDs {
+		ids[name] = *a
+	}
+	return writeSave(path, saveKindWorld, WorldSave{
+		Players:  w.Players,
+		UnitIDs:  ids,
+		Treasury: w.Treasury,
+		Owners:   w.Owners,
+		Scores:   w.Scores,
+		Streaks:  w.HoldStreaks,
+		Fielded:  w.Fielded,
+		Treaties: w.Treaties,
+		GameOver: w.GameOver,
+		Paused:   w.Paused,
+		Turn:     w.Turn,
+		Seed:     w.Seed,
+		Rules:    *w.Rules,
+	})
+}
+
+// Load replaces the whole game with the save called name, dropping every
+// order queued for the current turn.
+func (w *World) Load(name string) error {
+	path, err := SavePath(routing.AuthorityUsername, name)
+	if err != nil {
+		return err
+	}
+	ws := WorldSave{}
+	err = readSave(path, saveKindWorld, &ws)
+	if err != nil {
+		return err
+	}
+	rules, err := loadRules(ws.Rules, w.Map)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.resolving != nil {
+		return errors.New("can not load while a turn is being resolved")
+	}
+	loaded := NewWorld()
+	loaded.ID = w.ID
+	loaded.MaxPlayers = w.MaxPlayers
+	loaded.Map = w.Map
+	loaded.Rules = rules
+	for name, p := range ws.Players {
+		loaded.Players[name] = upgradePlayer(rules, p)
+		ids := ws.UnitIDs[name]
+		loaded.UnitIDs[name] = &ids
+	}
+	copyMap(loaded.Treasury, ws.Treasury)
+	copyMap(loaded.Owners, ws.Owners)
+	copyMap(loaded.Scores, ws.Scores)
+	copyMap(loaded.HoldStreaks, ws.Streaks)
+	copyMap(loaded.Fielded, ws.Fielded)
+	copyMap(loaded.Treaties, ws.Treaties)
+	loaded.GameOver = ws.GameOver
+	loaded.Paused = ws.Paused
+	loaded.Turn = ws.Turn
+	loaded.Seed = ws.Seed
+	// The lock is the one callers already hold.
+	loaded.mu = w.mu
+	*w = *loaded
+	return nil
+}
+
+func copyMap[K comparable, V any](dst, src map[K]V) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// Sync builds the delta that resets a player to the canonical army, for
+// example after the game was loaded.
+func (w *World) Sync(username string) StateDelta {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.syncDelta(username)
+}
+
+func (w *World) syncDelta(username string) StateDelta {
+	snap := w.playerSnap(username)
+	last := 0
+	if ids, ok := w.UnitIDs[username]; ok {
+		last = ids.Last
+	}
+	return StateDelta{
+		Username:   username,
+		Turn:       w.Turn,
+		Snapshot:   &snap,
+		LastUnitID: last,
+		Treasury:   w.Treasury[username],
+	}
+}
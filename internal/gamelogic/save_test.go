@@ -0,0 +1,88 @@
+package gamelogic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSave(t *testing.T) {
+	want := ClientSave{
+		Player:   Player{Username: "alice", Units: map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe", Health: 1}}},
+		Turn:     5,
+		Seed:     9,
+		Treasury: 12,
+	}
+	tests := []struct {
+		name    string
+		kind    string
+		tamper  func([]byte) []byte
+		wantErr bool
+	}{
+		{name: "round trip", kind: saveKindClient},
+		{name: "wrong kind", kind: saveKindWorld, wantErr: true},
+		{
+			name: "edited data",
+			kind: saveKindClient,
+			tamper: func(file []byte) []byte {
+				return bytes.Replace(file, []byte(`"Treasury": 12`), []byte(`"Treasury": 99`), 1)
+			},
+			wantErr: true,
+		},
+		{
+			name: "truncated",
+			kind: saveKindClient,
+			tamper: func(file []byte) []byte {
+				return file[:len(file)/2]
+			},
+			wantErr: true,
+		},
+		{
+			name: "from a newer build",
+			kind: saveKindClient,
+			tamper: func(file []byte) []byte {
+				return bytes.Replace(file, []byte(`"Format": 1`), []byte(`"Format": 2`), 1)
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.save")
+			err := writeSave(path, saveKindClient, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				file, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tampered := tt.tamper(file)
+				if bytes.Equal(tampered, file) {
+					t.Fatal("tampering left the save as it was")
+				}
+				err = os.WriteFile(path, tampered, 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := ClientSave{}
+			err = readSave(path, tt.kind, &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("read the save, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -5,30 +5,38 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+func (gs *GameState) CommandSpawn(words []string) (Unit, error) {
+	if gs.isOver() {
+		return Unit{}, errGameOver
+	}
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return Unit{}, errors.New("usage: spawn <location> <rank>")
 	}
 
+	rules := gs.GetRules()
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	if !gs.Map.HasLocation(Location(locationName)) || !rules.AllowsLocation(Location(locationName)) {
+		return Unit{}, fmt.Errorf("error: %s is not a valid location", locationName)
 	}
 
 	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	rr, ok := rules.Rank(UnitRank(rank))
+	if !ok {
+		return Unit{}, fmt.Errorf("error: %s is not a valid unit", rank)
+	}
+
+	err := rules.CheckSpawn(gs.GetPlayerSnap().Units, Location(locationName))
+	if err != nil {
+		return Unit{}, fmt.Errorf("error: %v", err)
+	}
+	err = gs.spend(rr.Cost)
+	if err != nil {
+		return Unit{}, fmt.Errorf("error: %v", err)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
-		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
+	id := gs.nextUnitID()
+	unit := rules.NewUnit(id, UnitRank(rank), Location(locationName))
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+	fmt.Printf("Ordered a(n) %s to spawn in %s with id %v at the end of the turn\n", rank, locationName, id)
+	return unit, nil
 }
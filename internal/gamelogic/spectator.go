@@ -0,0 +1,168 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// FeedLength is how many lines of the live feed a spectator keeps.
+const FeedLength = 50
+
+// WorldSummary is the public state of a game after a turn, published for
+// spectators: how many units each player has, who owns what and the scores.
+type WorldSummary struct {
+	GameID   string
+	Turn     int
+	Armies   map[string]int
+	Owners   map[Location]string
+	Scores   map[string]int
+	GameOver *GameOver
+}
+
+// Summary describes the game as it is now, for spectators.
+func (w *World) Summary() WorldSummary {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ws := WorldSummary{
+		GameID:   w.ID,
+		Turn:     w.Turn,
+		Armies:   map[string]int{},
+		Owners:   map[Location]string{},
+		Scores:   map[string]int{},
+		GameOver: w.GameOver,
+	}
+	for name, p := range w.Players {
+		ws.Armies[name] = len(p.Units)
+	}
+	copyMap(ws.Owners, w.Owners)
+	copyMap(ws.Scores, w.Scores)
+	return ws
+}
+
+// Spectator follows a game without playing in it. It has no Player, so there
+// is nothing for it to order or to fight with; it only keeps a feed of what
+// happened and the latest summary.
+type Spectator struct {
+	GameID  string
+	Paused  bool
+	Feed    []string
+	Summary *WorldSummary
+	mu      *sync.RWMutex
+}
+
+func NewSpectator(gameID string) *Spectator {
+	return &Spectator{
+		GameID: gameID,
+		mu:     &sync.RWMutex{},
+	}
+}
+
+// post adds a line to the feed and prints it.
+func (s *Spectator) post(line string) {
+	line = fmt.Sprintf("%v %s", time.Now().Format(time.TimeOnly), line)
+	s.mu.Lock()
+	s.Feed = append(s.Feed, line)
+	if len(s.Feed) > FeedLength {
+		s.Feed = s.Feed[len(s.Feed)-FeedLength:]
+	}
+	s.mu.Unlock()
+	fmt.Println()
+	fmt.Println(line)
+}
+
+func (s *Spectator) HandleMove(am ArmyMove) {
+	s.post(fmt.Sprintf("%s moved %d unit(s) to %s", am.Player.Username, len(am.Units), am.ToLocation))
+}
+
+func (s *Spectator) HandleWar(ws WarSummary) {
+	s.post(ws.Message())
+}
+
+func (s *Spectator) HandleGameLog(gl routing.GameLog) {
+	s.post(fmt.Sprintf("[%s] %s", gl.Username, gl.Message))
+}
+
+func (s *Spectator) HandlePause(ps routing.PlayingState) {
+	s.mu.Lock()
+	s.Paused = ps.IsPaused
+	s.mu.Unlock()
+	if ps.IsPaused {
+		s.post("the game was paused")
+	} else {
+		s.post("the game was resumed")
+	}
+}
+
+func (s *Spectator) HandleSummary(ws WorldSummary) {
+	s.mu.Lock()
+	s.Summary = &ws
+	s.mu.Unlock()
+	s.post(fmt.Sprintf("turn %d has started", ws.Turn))
+	if ws.GameOver != nil {
+		s.post(fmt.Sprintf("the game is over: %v %s", ws.GameOver.Winners, ws.GameOver.Reason))
+	}
+}
+
+// CommandSummary prints who is playing, how big their armies are and what
+// they hold.
+func (s *Spectator) CommandSummary() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Printf("==== %s ====\n", GameName(s.GameID))
+	if s.Paused {
+		fmt.Println("The game is paused.")
+	}
+	if s.Summary == nil {
+		fmt.Println("Waiting for the first turn to be resolved.")
+		return
+	}
+	ws := s.Summary
+	fmt.Printf("Turn %d.\n", ws.Turn)
+	held := map[string][]Location{}
+	for loc, owner := range ws.Owners {
+		held[owner] = append(held[owner], loc)
+	}
+	names := []string{}
+	for name := range ws.Armies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	slices.SortStableFunc(names, func(a, b string) int {
+		return ws.Scores[b] - ws.Scores[a]
+	})
+	for _, name := range names {
+		slices.Sort(held[name])
+		fmt.Printf("* %s: %d unit(s), score %d, holds %v\n", name, ws.Armies[name], ws.Scores[name], held[name])
+	}
+	if ws.GameOver != nil {
+		fmt.Printf("%v %s.\n", ws.GameOver.Winners, ws.GameOver.Reason)
+	}
+}
+
+// CommandFeed prints the latest lines of the feed.
+func (s *Spectator) CommandFeed() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.Feed) == 0 {
+		fmt.Println("Nothing has happened yet.")
+		return
+	}
+	for _, line := range s.Feed {
+		fmt.Println(line)
+	}
+}
+
+func PrintSpectatorHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* summary")
+	fmt.Println("* feed")
+	fmt.Println("    (the last few things that happened)")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
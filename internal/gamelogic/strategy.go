@@ -0,0 +1,444 @@
+package gamelogic
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+// SpawnPlan asks for a unit of a rank to be spawned somewhere.
+type SpawnPlan struct {
+	Location Location
+	Rank     UnitRank
+}
+
+// MovePlan asks for some of the bot's units to move somewhere.
+type MovePlan struct {
+	To      Location
+	UnitIDs []int
+}
+
+// Orders are what a strategy wants to do in a turn. They are only wishes:
+// CarryOut checks them the same way a player's commands are checked.
+type Orders struct {
+	Spawns []SpawnPlan
+	Moves  []MovePlan
+}
+
+// Strategy decides a bot's orders for a turn from its own state and the moves
+// it saw the other players make during the last one.
+type Strategy interface {
+	Plan(gs *GameState, observed []ArmyMove) Orders
+}
+
+const (
+	StrategyRandom     = "random"
+	StrategyAggressive = "aggressive"
+	StrategyDefensive  = "defensive"
+)
+
+// Strategies lists the built-in strategies by name.
+var Strategies = []string{StrategyRandom, StrategyAggressive, StrategyDefensive}
+
+// NewStrategy returns the built-in strategy called name. seed drives every
+// choice it makes at random, so a bot can be made to repeat a game.
+func NewStrategy(name string, seed int64) (Strategy, error) {
+	rng := rand.New(rand.NewSource(seed))
+	switch name {
+	case StrategyRandom:
+		return RandomStrategy{rng: rng}, nil
+	case StrategyAggressive:
+		return AggressiveStrategy{rng: rng}, nil
+	case StrategyDefensive:
+		return DefensiveStrategy{rng: rng}, nil
+	}
+	return nil, fmt.Errorf("%s is not a strategy, pick one of %v", name, Strategies)
+}
+
+// CarryOut turns a strategy's orders into the spawn orders and moves to
+// publish. Orders a player would not be allowed to give are printed and
+// dropped.
+func (gs *GameState) CarryOut(o Orders) ([]SpawnOrder, []ArmyMove) {
+	spawns := []SpawnOrder{}
+	for _, sp := range o.Spawns {
+		unit, err := gs.CommandSpawn([]string{"spawn", string(sp.Location), string(sp.Rank)})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		spawns = append(spawns, SpawnOrder{
+			Username: gs.GetUsername(),
+			Unit:     unit,
+		})
+	}
+	moves := []ArmyMove{}
+	for _, mp := range o.Moves {
+		words := []string{"move", string(mp.To)}
+		for _, id := range mp.UnitIDs {
+			words = append(words, fmt.Sprint(id))
+		}
+		am, err := gs.CommandMove(words)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		moves = append(moves, am)
+	}
+	return spawns, moves
+}
+
+// RandomStrategy spawns a couple of units wherever it can and wanders about.
+type RandomStrategy struct {
+	rng *rand.Rand
+}
+
+func (s RandomStrategy) Plan(gs *GameState, _ []ArmyMove) Orders {
+	o := Orders{}
+	army := newPlannedArmy(gs)
+	for range s.rng.Intn(3) {
+		ranks := army.affordable()
+		if len(ranks) == 0 {
+			break
+		}
+		locs := army.spawnable()
+		if len(locs) == 0 {
+			break
+		}
+		sp := SpawnPlan{
+			Location: locs[s.rng.Intn(len(locs))],
+			Rank:     ranks[s.rng.Intn(len(ranks))].Rank,
+		}
+		army.spawn(sp)
+		o.Spawns = append(o.Spawns, sp)
+	}
+	moves := map[Location][]int{}
+	for _, u := range army.units() {
+		if s.rng.Intn(2) == 0 {
+			continue
+		}
+		reachable := []Location{}
+		for _, n := range gs.Map.Neighbors(u.Location) {
+			if army.rules.CanReach(gs.Map, u, n) {
+				reachable = append(reachable, n)
+			}
+		}
+		if len(reachable) == 0 {
+			continue
+		}
+		to := reachable[s.rng.Intn(len(reachable))]
+		moves[to] = append(moves[to], u.ID)
+	}
+	o.Moves = movePlans(moves)
+	return o
+}
+
+// AggressiveStrategy spends everything on its strongest units and marches
+// every one of them at the closest enemy it knows of.
+type AggressiveStrategy struct {
+	rng *rand.Rand
+}
+
+func (s AggressiveStrategy) Plan(gs *GameState, observed []ArmyMove) Orders {
+	o := Orders{}
+	army := newPlannedArmy(gs)
+
+	// Anything an enemy holds, stands on or was last seen heading to is
+	// worth attacking. Without any, the bot goes after land it does not own.
+	targets := map[Location]bool{}
+	for loc, owner := range army.owners {
+		if owner != "" && owner != army.username && gs.isHostile(owner) {
+			targets[loc] = true
+		}
+	}
+	for _, enemy := range gs.GetEnemiesSnap() {
+		if !gs.isHostile(enemy.Username) {
+			continue
+		}
+		for _, u := range enemy.Units {
+			targets[u.Location] = true
+		}
+	}
+	for _, am := range observed {
+		if am.Player.Username != army.username && gs.isHostile(am.Player.Username) {
+			targets[am.ToLocation] = true
+		}
+	}
+	if len(targets) == 0 {
+		for _, loc := range gs.Map.Locations {
+			if army.owners[loc] != army.username {
+				targets[loc] = true
+			}
+		}
+	}
+
+	for {
+		ranks := army.affordable()
+		if len(ranks) == 0 {
+			break
+		}
+		strongest := slices.MaxFunc(ranks, func(a, b RankRule) int {
+			if a.Power != b.Power {
+				return a.Power - b.Power
+			}
+			return b.Cost - a.Cost
+		})
+		locs := army.spawnable()
+		if len(locs) == 0 {
+			break
+		}
+		// Spawn as close to the fighting as possible.
+		slices.SortStableFunc(locs, func(a, b Location) int {
+			return nearest(gs.Map, a, targets) - nearest(gs.Map, b, targets)
+		})
+		sp := SpawnPlan{Location: locs[0], Rank: strongest.Rank}
+		army.spawn(sp)
+		o.Spawns = append(o.Spawns, sp)
+	}
+
+	moves := map[Location][]int{}
+	for _, u := range army.units() {
+		target, ok := closest(gs.Map, u.Location, targets, s.rng)
+		if !ok || target == u.Location {
+			continue
+		}
+		to, ok := stepToward(gs.Map, army.rules, u, target)
+		if ok {
+			moves[to] = append(moves[to], u.ID)
+		}
+	}
+	o.Moves = movePlans(moves)
+	return o
+}
+
+// DefensiveStrategy keeps half its money in reserve, spends the rest on
+// whatever gives it the most power for the price and only moves to reinforce
+// its own land when enemies come near.
+type DefensiveStrategy struct {
+	rng *rand.Rand
+}
+
+func (s DefensiveStrategy) Plan(gs *GameState, observed []ArmyMove) Orders {
+	o := Orders{}
+	army := newPlannedArmy(gs)
+
+	// Home is what the bot owns, or failing that where its army stands.
+	home := map[Location]bool{}
+	for loc, owner := range army.owners {
+		if owner == army.username {
+			home[loc] = true
+		}
+	}
+	if len(home) == 0 {
+		for _, u := range army.units() {
+			home[u.Location] = true
+		}
+	}
+
+	// Home is threatened when an enemy was seen in or next to it.
+	threats := []Location{}
+	for _, enemy := range gs.GetEnemiesSnap() {
+		if !gs.isHostile(enemy.Username) {
+			continue
+		}
+		for _, u := range enemy.Units {
+			threats = append(threats, u.Location)
+		}
+	}
+	for _, am := range observed {
+		if am.Player.Username != army.username && gs.isHostile(am.Player.Username) {
+			threats = append(threats, am.ToLocation)
+		}
+	}
+	threatened := map[Location]bool{}
+	for _, t := range threats {
+		for loc := range home {
+			if loc == t || gs.Map.Adjacent(loc, t) {
+				threatened[loc] = true
+			}
+		}
+	}
+
+	reserve := army.budget / 2
+	for army.budget > reserve {
+		ranks := army.affordable()
+		ranks = slices.DeleteFunc(ranks, func(rr RankRule) bool {
+			return army.budget-rr.Cost < reserve
+		})
+		if len(ranks) == 0 {
+			break
+		}
+		best := slices.MaxFunc(ranks, func(a, b RankRule) int {
+			return a.Power*b.Cost - b.Power*a.Cost
+		})
+		locs := army.spawnable()
+		if len(locs) == 0 {
+			break
+		}
+		// Guard threatened land first, then the rest of home.
+		preferred := slices.DeleteFunc(slices.Clone(locs), func(loc Location) bool {
+			return !threatened[loc]
+		})
+		if len(preferred) == 0 {
+			preferred = slices.DeleteFunc(slices.Clone(locs), func(loc Location) bool {
+				return !home[loc]
+			})
+		}
+		if len(preferred) == 0 {
+			preferred = locs
+		}
+		sp := SpawnPlan{
+			Location: preferred[s.rng.Intn(len(preferred))],
+			Rank:     best.Rank,
+		}
+		army.spawn(sp)
+		o.Spawns = append(o.Spawns, sp)
+	}
+
+	moves := map[Location][]int{}
+	for _, u := range army.units() {
+		if threatened[u.Location] {
+			continue
+		}
+		target, ok := closest(gs.Map, u.Location, threatened, s.rng)
+		if !ok {
+			continue
+		}
+		to, ok := stepToward(gs.Map, army.rules, u, target)
+		if ok {
+			moves[to] = append(moves[to], u.ID)
+		}
+	}
+	o.Moves = movePlans(moves)
+	return o
+}
+
+// maxPlannedSpawns caps how many units a strategy orders in one turn, which
+// matters when a ruleset has units that cost nothing.
+const maxPlannedSpawns = 10
+
+// plannedArmy is a bot's army and treasury as they will be once the orders
+// planned so far are carried out, so a strategy does not plan more than it
+// can pay for or the spawn limits allow.
+type plannedArmy struct {
+	username string
+	army     map[int]Unit
+	budget   int
+	spawned  int
+	owners   map[Location]string
+	rules    *Ruleset
+	m        *Map
+}
+
+func newPlannedArmy(gs *GameState) *plannedArmy {
+	return &plannedArmy{
+		username: gs.GetUsername(),
+		army:     gs.GetPlayerSnap().Units,
+		budget:   gs.GetTreasury(),
+		owners:   gs.GetOwnersSnap(),
+		rules:    gs.GetRules(),
+		m:        gs.Map,
+	}
+}
+
+// units returns the army as it is now, leaving out planned spawns, ordered
+// by ID.
+func (pa *plannedArmy) units() []Unit {
+	units := []Unit{}
+	for _, u := range pa.army {
+		if u.ID > 0 {
+			units = append(units, u)
+		}
+	}
+	slices.SortFunc(units, func(a, b Unit) int {
+		return a.ID - b.ID
+	})
+	return units
+}
+
+func (pa *plannedArmy) affordable() []RankRule {
+	ranks := []RankRule{}
+	if pa.spawned >= maxPlannedSpawns {
+		return ranks
+	}
+	for _, rr := range pa.rules.Ranks {
+		if rr.Cost <= pa.budget {
+			ranks = append(ranks, rr)
+		}
+	}
+	return ranks
+}
+
+func (pa *plannedArmy) spawnable() []Location {
+	locs := []Location{}
+	for _, loc := range pa.rules.Locations {
+		if pa.m.HasLocation(loc) && pa.rules.CheckSpawn(pa.army, loc) == nil {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// spawn counts a planned unit against the budget and the spawn limits. It
+// gets a negative ID since the real one is only picked when it is ordered.
+func (pa *plannedArmy) spawn(sp SpawnPlan) {
+	rr, _ := pa.rules.Rank(sp.Rank)
+	pa.budget -= rr.Cost
+	pa.spawned++
+	pa.army[-pa.spawned] = Unit{ID: -pa.spawned, Rank: sp.Rank, Location: sp.Location}
+}
+
+// nearest is how many hops from loc the closest of targets is, or a large
+// number when none can be reached.
+func nearest(m *Map, loc Location, targets map[Location]bool) int {
+	best := len(m.Locations) + 1
+	for t := range targets {
+		hops := m.Hops(loc, t)
+		if hops >= 0 && hops < best {
+			best = hops
+		}
+	}
+	return best
+}
+
+// closest picks the target fewest hops from loc, breaking ties at random.
+func closest(m *Map, loc Location, targets map[Location]bool, rng *rand.Rand) (Location, bool) {
+	best := nearest(m, loc, targets)
+	candidates := []Location{}
+	for t := range targets {
+		if m.Hops(loc, t) == best {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	slices.Sort(candidates)
+	return candidates[rng.Intn(len(candidates))], true
+}
+
+// stepToward returns the next location along the quickest route to target,
+// if u can get there this turn.
+func stepToward(m *Map, rules *Ruleset, u Unit, target Location) (Location, bool) {
+	path, _, err := rules.Route(m, u, target)
+	if err != nil || len(path) < 2 || !rules.CanReach(m, u, path[1]) {
+		return "", false
+	}
+	return path[1], true
+}
+
+func movePlans(moves map[Location][]int) []MovePlan {
+	plans := []MovePlan{}
+	for to, ids := range moves {
+		plans = append(plans, MovePlan{To: to, UnitIDs: ids})
+	}
+	slices.SortFunc(plans, func(a, b MovePlan) int {
+		if a.To < b.To {
+			return -1
+		}
+		if a.To > b.To {
+			return 1
+		}
+		return 0
+	})
+	return plans
+}
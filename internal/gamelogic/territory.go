@@ -0,0 +1,174 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// GameOver is broadcast once a win condition is met. Winners holds more than
+// one player when the game ends in a tie.
+type GameOver struct {
+	Turn    int
+	Reason  string
+	Winners []string
+	Scores  map[string]int
+}
+
+// updateOwners hands every location to the only player with units in it. A
+// location nobody is in, or that is still contested after the battles, keeps
+// its previous owner.
+func (w *World) updateOwners() {
+	for _, loc := range w.Map.Locations {
+		present := []string{}
+		for _, name := range w.usernames() {
+			if len(unitsInLocation(w.Players[name], loc)) > 0 {
+				present = append(present, name)
+			}
+		}
+		if len(present) == 1 {
+			w.Owners[loc] = present[0]
+		}
+	}
+}
+
+func (w *World) ownedLocations(username string) []Location {
+	locs := []Location{}
+	for _, loc := range w.Map.Locations {
+		if w.Owners[loc] == username {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// scoreTerritory adds a point per owned location to every score and keeps
+// track of how long each player has held enough locations to win. Every hold
+// condition has its own streak, so a ruleset can have several.
+func (w *World) scoreTerritory() {
+	for _, name := range w.usernames() {
+		owned := len(w.ownedLocations(name))
+		w.Scores[name] += owned
+		streaks := w.HoldStreaks[name]
+		if len(streaks) < len(w.Rules.WinConditions) {
+			streaks = append(streaks, make([]int, len(w.Rules.WinConditions)-len(streaks))...)
+		}
+		for i, wc := range w.Rules.WinConditions {
+			if wc.Kind != WinHoldLocations {
+				continue
+			}
+			if owned >= wc.Count {
+				streaks[i]++
+			} else {
+				streaks[i] = 0
+			}
+		}
+		w.HoldStreaks[name] = streaks
+	}
+}
+
+// checkVictory returns how the game ended, or nil if it goes on. Conditions
+// are checked in the order the ruleset lists them.
+func (w *World) checkVictory() *GameOver {
+	for i, wc := range w.Rules.WinConditions {
+		winners := []string{}
+		reason := ""
+		switch wc.Kind {
+		case WinHoldLocations:
+			for _, name := range w.usernames() {
+				streaks := w.HoldStreaks[name]
+				if i < len(streaks) && streaks[i] >= wc.Ticks {
+					winners = append(winners, name)
+				}
+			}
+			reason = fmt.Sprintf("held %d location(s) for %d turn(s)", wc.Count, wc.Ticks)
+		case WinEliminate:
+			// Only players who have fielded an army can be eliminated, so a
+			// game does not end before anyone else has joined.
+			fielded, alive := 0, []string{}
+			for _, name := range w.usernames() {
+				if !w.Fielded[name] {
+					continue
+				}
+				fielded++
+				if len(w.Players[name].Units) > 0 {
+					alive = append(alive, name)
+				}
+			}
+			if fielded > 1 && len(alive) == 1 {
+				winners = alive
+			}
+			reason = "eliminated every opponent"
+		case WinHighestScore:
+			if w.Turn < wc.Ticks {
+				continue
+			}
+			best := -1
+			for _, name := range w.usernames() {
+				if w.Scores[name] > best {
+					best, winners = w.Scores[name], []string{name}
+				} else if w.Scores[name] == best {
+					winners = append(winners, name)
+				}
+			}
+			reason = fmt.Sprintf("had the highest score after %d turn(s)", wc.Ticks)
+		}
+		if len(winners) > 0 {
+			scores := map[string]int{}
+			for name, score := range w.Scores {
+				scores[name] = score
+			}
+			return &GameOver{
+				Turn:    w.Turn,
+				Reason:  reason,
+				Winners: winners,
+				Scores:  scores,
+			}
+		}
+	}
+	return nil
+}
+
+func (w *World) IsOver() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.GameOver != nil
+}
+
+var errGameOver = errors.New("the game is over")
+
+// HandleGameOver ends the game locally. No more orders are accepted.
+func (gs *GameState) HandleGameOver(g GameOver) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	gs.record(Event{Kind: EventGameOver, GameOver: &g})
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	if len(g.Winners) == 1 {
+		fmt.Printf("%s %s and won the game!\n", g.Winners[0], g.Reason)
+	} else {
+		fmt.Printf("%v %s and share the win!\n", g.Winners, g.Reason)
+	}
+	if slices.Contains(g.Winners, gs.GetUsername()) {
+		fmt.Println("Congratulations!")
+	}
+	names := []string{}
+	for name := range g.Scores {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return g.Scores[b] - g.Scores[a]
+	})
+	fmt.Println("Final scores:")
+	for _, name := range names {
+		fmt.Printf("* %s: %d\n", name, g.Scores[name])
+	}
+}
+
+func (gs *GameState) isOver() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameOver != nil
+}
@@ -0,0 +1,108 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+)
+
+func (gs *GameState) GetTurn() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Turn
+}
+
+func (gs *GameState) GetSeed() int64 {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Seed
+}
+
+// CommandDone tells the server you have no more orders this turn. The turn
+// number is only known once the first turn result has arrived.
+func (gs *GameState) CommandDone() (TurnSubmission, error) {
+	if gs.isPaused() {
+		return TurnSubmission{}, errors.New("the game is paused, there is no turn to finish")
+	}
+	if gs.isOver() {
+		return TurnSubmission{}, errGameOver
+	}
+	turn := gs.GetTurn()
+	if turn == 0 {
+		return TurnSubmission{}, errors.New("the current turn is not known yet, wait for the next turn result")
+	}
+	fmt.Printf("Finished turn %d, waiting for the other players\n", turn)
+	return TurnSubmission{
+		Username: gs.GetUsername(),
+		Turn:     turn,
+	}, nil
+}
+
+// GetOwnedLocations returns the locations you owned at the end of the last
+// turn.
+func (gs *GameState) GetOwnedLocations() []Location {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	locs := []Location{}
+	for _, loc := range gs.Map.Locations {
+		if gs.Owners[loc] == gs.Player.Username {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+// GetOwnersSnap returns who owned each location at the end of the last turn.
+func (gs *GameState) GetOwnersSnap() map[Location]string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	owners := map[Location]string{}
+	copyMap(owners, gs.Owners)
+	return owners
+}
+
+// HandleTurnResult prints what you saw happen at the end of a turn. The
+// changes to your own army arrive separately as a StateDelta.
+func (gs *GameState) HandleTurnResult(tr TurnResult) {
+	defer fmt.Println("------------------------")
+	gs.mu.Lock()
+	for _, br := range tr.Battles {
+		gs.record(Event{Kind: EventBattleResolved, Battle: &br})
+	}
+	gs.record(Event{Kind: EventTurnStarted, Turn: tr.Turn + 1, Seed: tr.Seed, Owners: tr.Owners})
+	gs.Enemies = tr.Enemies
+	gs.BattleWarnings = nil
+	gs.mu.Unlock()
+
+	fmt.Println()
+	fmt.Printf("==== Turn %d Resolved ====\n", tr.Turn)
+	if len(tr.Moves) == 0 && len(tr.Battles) == 0 {
+		fmt.Println("Nothing happened.")
+	}
+	for _, move := range tr.Moves {
+		fmt.Printf("* %s moved %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
+	}
+	for _, bc := range tr.Choices {
+		switch bc.Choice {
+		case ChoiceRetreat:
+			fmt.Printf("* %s retreated from %s to %s\n", bc.Username, bc.Location, bc.RetreatTo)
+		case ChoiceSurrender:
+			fmt.Printf("* %s surrendered in %s\n", bc.Username, bc.Location)
+		}
+	}
+	for _, br := range tr.Battles {
+		switch br.Outcome {
+		case WarOutcomeDraw:
+			fmt.Printf("* %s attacked %s in %s and it was a draw\n", br.Attacker, br.Defender, br.Location)
+		default:
+			fmt.Printf("* %s attacked %s in %s and %s won\n", br.Attacker, br.Defender, br.Location, br.Winner)
+		}
+	}
+	for _, ws := range tr.Wars {
+		fmt.Printf("* %s\n", ws.Message())
+	}
+	for _, enemy := range tr.Enemies {
+		fmt.Printf("* you can see %d of %s's unit(s)\n", len(enemy.Units), enemy.Username)
+	}
+	fmt.Printf("You own %d location(s) and have a score of %d.\n", len(gs.GetOwnedLocations()), tr.Scores[gs.GetUsername()])
+	fmt.Printf("Turn %d has started.\n", gs.GetTurn())
+}
@@ -0,0 +1,117 @@
+package gamelogic
+
+import (
+	"slices"
+)
+
+// Visibility returns the locations p can see: every location it has units in
+// and every location adjacent to one.
+func (m *Map) Visibility(p Player) map[Location]bool {
+	visible := map[Location]bool{}
+	for _, u := range p.Units {
+		visible[u.Location] = true
+		for _, n := range m.Neighbors(u.Location) {
+			visible[n] = true
+		}
+	}
+	return visible
+}
+
+// filterPlayer strips p down to the units in visible locations.
+func filterPlayer(p Player, visible map[Location]bool) Player {
+	units := map[int]Unit{}
+	for id, u := range p.Units {
+		if visible[u.Location] {
+			units[id] = u
+		}
+	}
+	return Player{
+		Username: p.Username,
+		Units:    units,
+	}
+}
+
+// ViewOf filters a resolved turn down to what viewer is allowed to see. A
+// player sees everything they were part of, and otherwise only what happened
+// in locations visible to their army once the turn has been resolved.
+func (w *World) ViewOf(tr TurnResult, viewer string) TurnResult {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	visible := w.Map.Visibility(w.Players[viewer])
+	for _, br := range tr.Battles {
+		if br.Attacker == viewer || br.Defender == viewer {
+			visible[br.Location] = true
+		}
+	}
+
+	view := TurnResult{
+		Turn:     tr.Turn,
+		Seed:     tr.Seed,
+		Viewer:   viewer,
+		Owners:   map[Location]string{},
+		Scores:   tr.Scores,
+		GameOver: tr.GameOver,
+	}
+	for _, move := range tr.Moves {
+		if move.Player.Username == viewer {
+			view.Moves = append(view.Moves, move)
+			continue
+		}
+		if !visible[move.ToLocation] {
+			continue
+		}
+		view.Moves = append(view.Moves, ArmyMove{
+			Player:     filterPlayer(move.Player, visible),
+			Units:      move.Units,
+			ToLocation: move.ToLocation,
+		})
+	}
+	for _, bc := range tr.Choices {
+		if bc.Username == viewer || visible[bc.Location] {
+			view.Choices = append(view.Choices, bc)
+		}
+	}
+	for _, br := range tr.Battles {
+		if visible[br.Location] {
+			view.Battles = append(view.Battles, br)
+		}
+	}
+	for _, ws := range tr.Wars {
+		if ws.Attacker == viewer || ws.Defender == viewer || slices.ContainsFunc(ws.Locations, func(loc Location) bool { return visible[loc] }) {
+			view.Wars = append(view.Wars, ws)
+		}
+	}
+	for loc, owner := range tr.Owners {
+		if owner == viewer || visible[loc] {
+			view.Owners[loc] = owner
+		}
+	}
+	for _, name := range w.usernames() {
+		if name == viewer {
+			continue
+		}
+		enemy := filterPlayer(w.Players[name], visible)
+		if len(enemy.Units) > 0 {
+			view.Enemies = append(view.Enemies, enemy)
+		}
+	}
+	for loc := range visible {
+		view.Visible = append(view.Visible, loc)
+	}
+	slices.Sort(view.Visible)
+	return view
+}
+
+// Viewers returns every player a turn has to be shown to.
+func (w *World) Viewers() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.usernames()
+}
+
+func (gs *GameState) GetEnemiesSnap() []Player {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]Player{}, gs.Enemies...)
+}
@@ -0,0 +1,50 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PrivateMessage struct {
+	From       string
+	Text       string
+	ReceivedAt time.Time
+}
+
+// CommandWhisper parses "whisper <user> <text>" into its recipient and text.
+func (gs *GameState) CommandWhisper(words []string) (to string, text string, err error) {
+	if len(words) < 3 {
+		return "", "", errors.New("usage: whisper <user> <text>")
+	}
+	to = words[1]
+	if to == gs.GetUsername() {
+		return "", "", errors.New("you can not whisper to yourself")
+	}
+	return to, strings.Join(words[2:], " "), nil
+}
+
+func (gs *GameState) ReceiveWhisper(from, text string) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Whisper Received ====")
+	fmt.Printf("%s whispers: %s\n", from, text)
+	gs.addToInbox(PrivateMessage{
+		From:       from,
+		Text:       text,
+		ReceivedAt: time.Now(),
+	})
+}
+
+func (gs *GameState) CommandInbox() {
+	inbox := gs.GetInboxSnap()
+	if len(inbox) == 0 {
+		fmt.Println("Your inbox is empty.")
+		return
+	}
+	fmt.Printf("You have %d message(s):\n", len(inbox))
+	for _, m := range inbox {
+		fmt.Printf("* %v %s: %s\n", m.ReceivedAt.Format(time.Kitchen), m.From, m.Text)
+	}
+}
@@ -0,0 +1,571 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// World is the server's canonical copy of every player's army. Clients keep
+// their own GameState, but only orders the World accepts take effect. Orders
+// are queued during a turn and all take effect together when it is resolved.
+// A server can host several Worlds at once, told apart by their ID.
+type World struct {
+	ID          string
+	MaxPlayers  int
+	Players     map[string]Player
+	UnitIDs     map[string]*UnitIDAllocator
+	Treasury    map[string]int
+	Owners      map[Location]string
+	Scores      map[string]int
+	HoldStreaks map[string][]int
+	Fielded     map[string]bool
+	Treaties    map[string]Treaty
+	GameOver    *GameOver
+	Paused      bool
+	Turn        int
+	Seed        int64
+	Map         *Map
+	Rules       *Ruleset
+	spawns      []SpawnOrder
+	promotions  []promotion
+	moves       []ArmyMove
+	submitted   map[string]bool
+	views       map[string][]TurnResult
+	resolving   *resolution
+	mu          *sync.RWMutex
+}
+
+func NewWorld() *World {
+	return &World{
+		Players:     map[string]Player{},
+		UnitIDs:     map[string]*UnitIDAllocator{},
+		Treasury:    map[string]int{},
+		Owners:      map[Location]string{},
+		Scores:      map[string]int{},
+		HoldStreaks: map[string][]int{},
+		Fielded:     map[string]bool{},
+		Treaties:    map[string]Treaty{},
+		Paused:      false,
+		Turn:        1,
+		Map:         DefaultMap(),
+		Rules:       DefaultRuleset(),
+		submitted:   map[string]bool{},
+		views:       map[string][]TurnResult{},
+		mu:          &sync.RWMutex{},
+	}
+}
+
+func (w *World) SetPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Paused = paused
+}
+
+func (w *World) IsPaused() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.Paused
+}
+
+func (w *World) GetPlayerSnap(username string) Player {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.playerSnap(username)
+}
+
+func (w *World) playerSnap(username string) Player {
+	Units := map[int]Unit{}
+	for k, v := range w.Players[username].Units {
+		Units[k] = v
+	}
+	return Player{
+		Username: username,
+		Units:    Units,
+	}
+}
+
+// player returns the canonical player. It is only used while resolving
+// orders, and Leave drops a player's orders, so they are always in the game.
+func (w *World) player(username string) Player {
+	return w.Players[username]
+}
+
+var errNotJoined = errors.New("you have not joined this game")
+
+// lookup returns the canonical player an order came from, who has to have
+// joined through the lobby first.
+func (w *World) lookup(username string) (Player, error) {
+	p, ok := w.Players[username]
+	if !ok {
+		return Player{}, errNotJoined
+	}
+	return p, nil
+}
+
+// join adds a player to the game if there is room. A player who left and
+// came back keeps the treasury they had, so leaving is no way to get more
+// money.
+func (w *World) join(username string) error {
+	if _, ok := w.Players[username]; ok {
+		return nil
+	}
+	if w.MaxPlayers > 0 && len(w.Players) >= w.MaxPlayers {
+		return fmt.Errorf("the game is full (%d players)", w.MaxPlayers)
+	}
+	w.Players[username] = Player{
+		Username: username,
+		Units:    map[int]Unit{},
+	}
+	w.UnitIDs[username] = &UnitIDAllocator{}
+	if _, ok := w.Treasury[username]; !ok {
+		w.Treasury[username] = w.Rules.Economy.StartingFunds
+	}
+	return nil
+}
+
+// HasPlayer reports whether username is in the game.
+func (w *World) HasPlayer(username string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, ok := w.Players[username]
+	return ok
+}
+
+// Join adds a player to the game, if there is room.
+func (w *World) Join(username string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return errGameOver
+	}
+	return w.join(username)
+}
+
+// Leave takes a player and their army out of the game. Their orders for the
+// current turn are dropped and the territory they held is given up straight
+// away, so nobody is left owning land without an army. Their treasury is
+// kept in case they join again.
+func (w *World) Leave(username string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.Players[username]; !ok {
+		return fmt.Errorf("%s is not playing", username)
+	}
+	if w.resolving != nil {
+		return errors.New("can not leave while a turn is being resolved")
+	}
+	delete(w.Players, username)
+	delete(w.UnitIDs, username)
+	delete(w.HoldStreaks, username)
+	delete(w.Fielded, username)
+	delete(w.submitted, username)
+	delete(w.views, username)
+	for loc, owner := range w.Owners {
+		if owner == username {
+			delete(w.Owners, loc)
+		}
+	}
+	w.spawns = slices.DeleteFunc(w.spawns, func(so SpawnOrder) bool {
+		return so.Username == username
+	})
+	w.promotions = slices.DeleteFunc(w.promotions, func(queued promotion) bool {
+		return queued.order.Username == username
+	})
+	w.moves = slices.DeleteFunc(w.moves, func(am ArmyMove) bool {
+		return am.Player.Username == username
+	})
+	return nil
+}
+
+// Info summarises the game for the lobby.
+func (w *World) Info() GameInfo {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return GameInfo{
+		ID:         w.ID,
+		Players:    w.usernames(),
+		MaxPlayers: w.MaxPlayers,
+		Turn:       w.Turn,
+		Paused:     w.Paused,
+		Over:       w.GameOver != nil,
+	}
+}
+
+// usernames returns every player in a stable order.
+func (w *World) usernames() []string {
+	names := []string{}
+	for name := range w.Players {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// QueueSpawn checks a spawn against the current army and holds it until the
+// turn is resolved.
+func (w *World) QueueSpawn(so SpawnOrder) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return errGameOver
+	}
+	if w.Paused {
+		return errors.New("the game is paused, you can not spawn units")
+	}
+	if !w.Map.HasLocation(so.Unit.Location) || !w.Rules.AllowsLocation(so.Unit.Location) {
+		return fmt.Errorf("%s is not a valid location", so.Unit.Location)
+	}
+	rr, ok := w.Rules.Rank(so.Unit.Rank)
+	if !ok {
+		return fmt.Errorf("%s is not a valid unit", so.Unit.Rank)
+	}
+
+	// IDs have to keep increasing so a dead unit's ID is never handed out
+	// again.
+	_, err := w.lookup(so.Username)
+	if err != nil {
+		return err
+	}
+	ids := w.UnitIDs[so.Username]
+	if so.Unit.ID <= ids.Last {
+		return fmt.Errorf("unit ID %v has already been used", so.Unit.ID)
+	}
+	ids.Observe(so.Unit.ID)
+
+	// The cost is taken now so a player can not queue more units than they
+	// can pay for. It is refunded if the spawn fails when the turn ends.
+	if w.Treasury[so.Username] < rr.Cost {
+		return fmt.Errorf("a(n) %s costs %d but you only have %d", so.Unit.Rank, rr.Cost, w.Treasury[so.Username])
+	}
+	w.Treasury[so.Username] -= rr.Cost
+	// Only the server decides what a new unit's health and experience are.
+	so.Unit = w.Rules.NewUnit(so.Unit.ID, so.Unit.Rank, so.Unit.Location)
+	w.spawns = append(w.spawns, so)
+	return nil
+}
+
+// QueueMove checks a move against the current army and holds it until the
+// turn is resolved. Only the unit IDs and destination of am are trusted.
+func (w *World) QueueMove(am ArmyMove) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return errGameOver
+	}
+	if w.Paused {
+		return errors.New("the game is paused, you can not move units")
+	}
+	if !w.Map.HasLocation(am.ToLocation) {
+		return fmt.Errorf("%s is not a valid location", am.ToLocation)
+	}
+
+	p, err := w.lookup(am.Player.Username)
+	if err != nil {
+		return err
+	}
+	order := ArmyMove{
+		Player:     Player{Username: p.Username},
+		ToLocation: am.ToLocation,
+		Via:        am.Via,
+	}
+	for _, u := range am.Units {
+		unit, ok := p.Units[u.ID]
+		if !ok {
+			return fmt.Errorf("unit with ID %v not found", u.ID)
+		}
+		err := w.Rules.CheckMove(w.Map, unit, am.ToLocation, am.Via)
+		if err != nil {
+			return fmt.Errorf("unit %v can not move to %s in one turn: %v", u.ID, am.ToLocation, err)
+		}
+		order.Units = append(order.Units, Unit{ID: u.ID})
+	}
+	w.moves = append(w.moves, order)
+	return nil
+}
+
+// Submit marks a player as done with the current turn. allSubmitted is true
+// once every player is done, so the turn can be resolved early.
+func (w *World) Submit(ts TurnSubmission) (allSubmitted bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.GameOver != nil {
+		return false, errGameOver
+	}
+	if ts.Turn != w.Turn {
+		return false, fmt.Errorf("turn %d is not the current turn (%d)", ts.Turn, w.Turn)
+	}
+	_, err = w.lookup(ts.Username)
+	if err != nil {
+		return false, err
+	}
+	w.submitted[ts.Username] = true
+	return len(w.submitted) == len(w.Players), nil
+}
+
+// Reject builds the delta sent back when an order is refused, resetting the
+// player to the canonical army.
+func (w *World) Reject(username string, err error) StateDelta {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sd := w.syncDelta(username)
+	sd.Rejected = err.Error()
+	return sd
+}
+
+// ResolveTurn resolves the turn without giving defenders a chance to respond
+// to the battles coming their way, so every battle is fought.
+func (w *World) ResolveTurn() (TurnResult, []StateDelta, error) {
+	w.BeginTurn()
+	return w.FinishTurn()
+}
+
+// resolution carries a turn from BeginTurn to FinishTurn.
+type resolution struct {
+	result    TurnResult
+	deltas    map[string]*StateDelta
+	movedInto map[string]map[Location]bool
+	warnings  []BattleWarning
+	choices   map[string]BattleChoice
+}
+
+func (r *resolution) deltaFor(username string) *StateDelta {
+	sd, ok := r.deltas[username]
+	if !ok {
+		sd = &StateDelta{Username: username}
+		r.deltas[username] = sd
+	}
+	return sd
+}
+
+func (r *resolution) reject(username string, err error) {
+	sd := r.deltaFor(username)
+	sd.Rejected = strings.TrimPrefix(sd.Rejected+"; "+err.Error(), "; ")
+}
+
+// BeginTurn applies every queued order at once and works out which battles
+// they caused. It returns a warning for the defender of each battle, who can
+// respond with RespondToBattle until FinishTurn is called.
+func (w *World) BeginTurn() []BattleWarning {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	r := &resolution{
+		result:  TurnResult{Turn: w.Turn, Seed: w.Seed},
+		deltas:  map[string]*StateDelta{},
+		choices: map[string]BattleChoice{},
+	}
+	w.resolving = r
+
+	for _, so := range w.spawns {
+		p := w.player(so.Username)
+		err := w.Rules.CheckSpawn(p.Units, so.Unit.Location)
+		if err != nil {
+			rr, _ := w.Rules.Rank(so.Unit.Rank)
+			w.Treasury[so.Username] += rr.Cost
+			r.reject(so.Username, err)
+			continue
+		}
+		p.Units[so.Unit.ID] = so.Unit
+		w.Fielded[so.Username] = true
+		sd := r.deltaFor(so.Username)
+		sd.Upserted = append(sd.Upserted, so.Unit)
+	}
+
+	w.applyPromotions(r)
+	r.movedInto = w.applyMoves(&r.result, r.deltaFor, r.reject)
+	// Orders that arrive while defenders are responding are for the next
+	// turn.
+	w.spawns = nil
+	w.promotions = nil
+	w.moves = nil
+
+	names := w.usernames()
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			if !w.hostile(a, b) {
+				continue
+			}
+			for _, loc := range getOverlappingLocations(w.Players[a], w.Players[b]) {
+				attacker, defender := a, b
+				if r.movedInto[b][loc] && !r.movedInto[a][loc] {
+					attacker, defender = b, a
+				}
+				r.warnings = append(r.warnings, BattleWarning{
+					Turn:          w.Turn,
+					Location:      loc,
+					Attacker:      attacker,
+					Defender:      defender,
+					AttackerUnits: unitsInLocation(w.Players[attacker], loc),
+				})
+			}
+		}
+	}
+	return append([]BattleWarning{}, r.warnings...)
+}
+
+// FinishTurn carries out the defenders' responses, fights the remaining
+// battles and then settles territory, income and victory. It must follow
+// BeginTurn. It returns the result to broadcast and one delta per player,
+// including those whose army did not change, or an error when no turn was
+// begun.
+func (w *World) FinishTurn() (TurnResult, []StateDelta, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := w.resolving
+	if r == nil {
+		return TurnResult{}, nil, errors.New("no turn is being resolved")
+	}
+	w.resolving = nil
+
+	w.applyBattleChoices(r)
+
+	// Battles are grouped into one war per pair of players.
+	wars := map[string][]BattleReport{}
+	pairs := []string{}
+	for _, bw := range r.warnings {
+		b := NewBattle(w.Turn, bw.Location, w.Players[bw.Attacker], w.Players[bw.Defender])
+		if len(b.AttackerUnits) == 0 || len(b.DefenderUnits) == 0 {
+			continue
+		}
+		br := w.Rules.Resolver(w.Seed).Resolve(b)
+		killUnits(w.Players[bw.Attacker], br.AttackerLosses)
+		killUnits(w.Players[bw.Defender], br.DefenderLosses)
+		putUnits(w.Players[bw.Attacker], br.AttackerSurvivors)
+		putUnits(w.Players[bw.Defender], br.DefenderSurvivors)
+		sd := r.deltaFor(bw.Attacker)
+		sd.Removed = append(sd.Removed, br.AttackerLosses...)
+		sd.Upserted = append(sd.Upserted, br.AttackerSurvivors...)
+		sd = r.deltaFor(bw.Defender)
+		sd.Removed = append(sd.Removed, br.DefenderLosses...)
+		sd.Upserted = append(sd.Upserted, br.DefenderSurvivors...)
+
+		r.result.Battles = append(r.result.Battles, br)
+		pair := treatyKey(bw.Attacker, bw.Defender)
+		if _, ok := wars[pair]; !ok {
+			pairs = append(pairs, pair)
+		}
+		wars[pair] = append(wars[pair], br)
+	}
+	for _, pair := range pairs {
+		r.result.Wars = append(r.result.Wars, SummarizeWar(wars[pair]))
+	}
+
+	result := r.result
+	w.updateOwners()
+	w.collectIncome(r.deltaFor)
+	w.scoreTerritory()
+	w.GameOver = w.checkVictory()
+	result.GameOver = w.GameOver
+	result.Owners = map[Location]string{}
+	for loc, owner := range w.Owners {
+		result.Owners[loc] = owner
+	}
+	result.Scores = map[string]int{}
+	for name, score := range w.Scores {
+		result.Scores[name] = score
+	}
+
+	w.Turn++
+	w.submitted = map[string]bool{}
+
+	sds := []StateDelta{}
+	for _, name := range w.usernames() {
+		sd := r.deltaFor(name)
+		sd.Turn = w.Turn
+		sd.LastUnitID = w.UnitIDs[name].Last
+		sd.Treasury = w.Treasury[name]
+		sds = append(sds, *sd)
+	}
+	return result, sds, nil
+}
+
+// applyMoves moves every unit to its destination at the same time. When a
+// unit was given several orders in a turn the last one wins. It returns which
+// locations each player moved into.
+func (w *World) applyMoves(result *TurnResult, deltaFor func(string) *StateDelta, reject func(string, error)) map[string]map[Location]bool {
+	type unitKey struct {
+		username string
+		id       int
+	}
+	lastOrder := map[unitKey]int{}
+	for i, am := range w.moves {
+		for _, u := range am.Units {
+			lastOrder[unitKey{am.Player.Username, u.ID}] = i
+		}
+	}
+
+	movedInto := map[string]map[Location]bool{}
+	moved := map[string][]Unit{}
+	for i, am := range w.moves {
+		p := w.player(am.Player.Username)
+		units := []Unit{}
+		for _, u := range am.Units {
+			if lastOrder[unitKey{p.Username, u.ID}] != i {
+				continue
+			}
+			unit, ok := p.Units[u.ID]
+			if !ok {
+				reject(p.Username, fmt.Errorf("unit with ID %v no longer exists", u.ID))
+				continue
+			}
+			err := w.Rules.CheckMove(w.Map, unit, am.ToLocation, am.Via)
+			if err != nil {
+				reject(p.Username, fmt.Errorf("unit %v can not move to %s in one turn: %v", u.ID, am.ToLocation, err))
+				continue
+			}
+			unit.Location = am.ToLocation
+			units = append(units, unit)
+		}
+		if len(units) == 0 {
+			continue
+		}
+		moved[p.Username] = append(moved[p.Username], units...)
+		if movedInto[p.Username] == nil {
+			movedInto[p.Username] = map[Location]bool{}
+		}
+		movedInto[p.Username][am.ToLocation] = true
+		result.Moves = append(result.Moves, ArmyMove{
+			Player:     Player{Username: p.Username},
+			Units:      units,
+			ToLocation: am.ToLocation,
+		})
+	}
+
+	for username, units := range moved {
+		p := w.Players[username]
+		for _, unit := range units {
+			p.Units[unit.ID] = unit
+		}
+		sd := deltaFor(username)
+		sd.Upserted = append(sd.Upserted, units...)
+	}
+	for i := range result.Moves {
+		result.Moves[i].Player = w.playerSnap(result.Moves[i].Player.Username)
+	}
+	return movedInto
+}
+
+func killUnits(p Player, ids []int) {
+	for _, id := range ids {
+		delete(p.Units, id)
+	}
+}
+
+func putUnits(p Player, units []Unit) {
+	for _, u := range units {
+		p.Units[u.ID] = u
+	}
+}
+
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, u := range p.Units {
+		if u.Location == loc {
+			units = append(units, u)
+		}
+	}
+	return units
+}
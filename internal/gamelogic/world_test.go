@@ -0,0 +1,34 @@
+package gamelogic
+
+import "testing"
+
+func TestFinishTurn(t *testing.T) {
+	tests := []struct {
+		name     string
+		begin    bool
+		finished bool
+		wantErr  bool
+	}{
+		{name: "after BeginTurn", begin: true},
+		{name: "without BeginTurn", wantErr: true},
+		{name: "twice", begin: true, finished: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorld()
+			if tt.begin {
+				w.BeginTurn()
+			}
+			if tt.finished {
+				_, _, err := w.FinishTurn()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, _, err := w.FinishTurn()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
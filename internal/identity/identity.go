@@ -0,0 +1,356 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Signer holds a player's private keys: an ed25519 key for signing and an
+// X25519 key that whispers are encrypted to.
+type Signer struct {
+	Username string
+	key      ed25519.PrivateKey
+	box      *ecdh.PrivateKey
+}
+
+func NewSigner(username string) (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	box, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		Username: username,
+		key:      key,
+		box:      box,
+	}, nil
+}
+
+// configPath returns where a file lives in peril's config directory.
+func configPath(elem ...string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{dir, "peril"}, elem...)...), nil
+}
+
+// LoadOrCreateSigner reads the username's key from the user's config
+// directory, generating and saving one the first time. Reusing the key keeps a
+// restarted client from being rejected by the server's registry.
+func LoadOrCreateSigner(username string) (*Signer, error) {
+	path, err := configPath("keys", username+".key")
+	if err != nil {
+		return nil, err
+	}
+
+	// The file holds the ed25519 seed followed by the X25519 private key.
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if len(data) != ed25519.SeedSize+32 {
+			return nil, fmt.Errorf("key file %s is corrupt", path)
+		}
+		box, err := ecdh.X25519().NewPrivateKey(data[ed25519.SeedSize:])
+		if err != nil {
+			return nil, fmt.Errorf("key file %s is corrupt: %v", path, err)
+		}
+		return &Signer{
+			Username: username,
+			key:      ed25519.NewKeyFromSeed(data[:ed25519.SeedSize]),
+			box:      box,
+		}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read key file: %v", err)
+	}
+
+	s, err := NewSigner(username)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("could not create key directory: %v", err)
+	}
+	data = append(s.key.Seed(), s.box.Bytes()...)
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not write key file: %v", err)
+	}
+	return s, nil
+}
+
+func (s *Signer) Sign(body []byte) []byte {
+	return ed25519.Sign(s.key, body)
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s *Signer) Announcement() routing.PublicKeyAnnouncement {
+	return routing.PublicKeyAnnouncement{
+		Username:      s.Username,
+		SigningKey:    s.PublicKey(),
+		EncryptionKey: s.box.PublicKey().Bytes(),
+	}
+}
+
+// authorityKeyEnv names a file holding the server's public keys, for clients
+// that do not share the server's config directory.
+const authorityKeyEnv = "PERIL_AUTHORITY_KEY"
+
+// SaveAuthorityKey writes the server's public keys to the config directory,
+// where clients on the same machine load them from.
+func SaveAuthorityKey(s *Signer) error {
+	path, err := configPath("authority.pub")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(s.Announcement())
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("could not create key directory: %v", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write authority key: %v", err)
+	}
+	return nil
+}
+
+// LoadAuthorityKey reads the server's public keys saved by SaveAuthorityKey,
+// or the file named by PERIL_AUTHORITY_KEY when it is set.
+func LoadAuthorityKey() (routing.PublicKeyAnnouncement, error) {
+	path := os.Getenv(authorityKeyEnv)
+	if path == "" {
+		var err error
+		path, err = configPath("authority.pub")
+		if err != nil {
+			return routing.PublicKeyAnnouncement{}, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return routing.PublicKeyAnnouncement{}, fmt.Errorf("could not read the server's key (copy it from the server and set %s): %v", authorityKeyEnv, err)
+	}
+	a := routing.PublicKeyAnnouncement{}
+	err = json.Unmarshal(data, &a)
+	if err != nil {
+		return routing.PublicKeyAnnouncement{}, fmt.Errorf("server key file %s is corrupt: %v", path, err)
+	}
+	if a.Username != routing.AuthorityUsername {
+		return routing.PublicKeyAnnouncement{}, fmt.Errorf("server key file %s is for %s", path, a.Username)
+	}
+	err = validAnnouncement(a)
+	if err != nil {
+		return routing.PublicKeyAnnouncement{}, err
+	}
+	return a, nil
+}
+
+// Registry maps usernames to their public keys. Pinned keys come from
+// configuration rather than the network, so nothing the registry is told later
+// can replace them. A registry with a path saves every key it registers.
+type Registry struct {
+	keys   map[string]routing.PublicKeyAnnouncement
+	pinned map[string]routing.PublicKeyAnnouncement
+	path   string
+	mu     *sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		keys:   map[string]routing.PublicKeyAnnouncement{},
+		pinned: map[string]routing.PublicKeyAnnouncement{},
+		mu:     &sync.RWMutex{},
+	}
+}
+
+// NewPinnedRegistry is the registry a client verifies with: it trusts the
+// server's key from LoadAuthorityKey, and the registry the server publishes
+// can add players but never replace it.
+func NewPinnedRegistry() (*Registry, error) {
+	a, err := LoadAuthorityKey()
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry()
+	err = r.Pin(a)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRegistry opens the server's registry in the config directory, so a
+// username stays bound to the first key it registered across restarts.
+func LoadRegistry() (*Registry, error) {
+	path, err := configPath("registry.json")
+	if err != nil {
+		return nil, err
+	}
+	r := NewRegistry()
+	r.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read registry: %v", err)
+	}
+	kr := routing.KeyRegistry{}
+	err = json.Unmarshal(data, &kr)
+	if err != nil {
+		return nil, fmt.Errorf("registry %s is corrupt: %v", path, err)
+	}
+	for _, a := range kr.Keys {
+		err := validAnnouncement(a)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s is corrupt: %v", path, err)
+		}
+		r.keys[a.Username] = a
+	}
+	return r, nil
+}
+
+// Pin trusts a key that came from configuration rather than the network.
+func (r *Registry) Pin(a routing.PublicKeyAnnouncement) error {
+	err := validAnnouncement(a)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pinned[a.Username] = a
+	r.keys[a.Username] = a
+	return nil
+}
+
+// Register records the key from an announcement. The first key seen for a
+// username wins, so a later announcement with a different key is rejected.
+// The server's name can only be pinned, never announced.
+func (r *Registry) Register(a routing.PublicKeyAnnouncement) (added bool, err error) {
+	err = validAnnouncement(a)
+	if err != nil {
+		return false, err
+	}
+	if a.Username == routing.AuthorityUsername {
+		return false, fmt.Errorf("%s is reserved for the server", a.Username)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k, ok := r.keys[a.Username]; ok {
+		if !bytes.Equal(k.SigningKey, a.SigningKey) || !bytes.Equal(k.EncryptionKey, a.EncryptionKey) {
+			return false, fmt.Errorf("%s already registered different keys", a.Username)
+		}
+		return false, nil
+	}
+	r.keys[a.Username] = a
+	err = r.save()
+	if err != nil {
+		delete(r.keys, a.Username)
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes every key that is not pinned to the registry's file, if it has
+// one. The file is replaced in one step so a crash never leaves half of it.
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+	kr := routing.KeyRegistry{}
+	for name, a := range r.keys {
+		if _, ok := r.pinned[name]; ok {
+			continue
+		}
+		kr.Keys = append(kr.Keys, a)
+	}
+	data, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0700)
+	if err != nil {
+		return fmt.Errorf("could not create registry directory: %v", err)
+	}
+	tmp := r.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write registry: %v", err)
+	}
+	err = os.Rename(tmp, r.path)
+	if err != nil {
+		return fmt.Errorf("could not write registry: %v", err)
+	}
+	return nil
+}
+
+func validAnnouncement(a routing.PublicKeyAnnouncement) error {
+	if len(a.SigningKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("signing key for %s has the wrong size: %v", a.Username, len(a.SigningKey))
+	}
+	_, err := ecdh.X25519().NewPublicKey(a.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("encryption key for %s is invalid: %v", a.Username, err)
+	}
+	return nil
+}
+
+// Replace swaps in the registry published by the server, keeping the pinned
+// keys whatever it says about them.
+func (r *Registry) Replace(kr routing.KeyRegistry) {
+	keys := map[string]routing.PublicKeyAnnouncement{}
+	for _, a := range kr.Keys {
+		if validAnnouncement(a) == nil {
+			keys[a.Username] = a
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, a := range r.pinned {
+		keys[name] = a
+	}
+	r.keys = keys
+}
+
+func (r *Registry) Snapshot() routing.KeyRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	kr := routing.KeyRegistry{}
+	for _, a := range r.keys {
+		kr.Keys = append(kr.Keys, a)
+	}
+	return kr
+}
+
+func (r *Registry) Verify(username string, body, signature []byte) error {
+	r.mu.RLock()
+	k, ok := r.keys[username]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no signing key registered for %s", username)
+	}
+	if !ed25519.Verify(k.SigningKey, body, signature) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestPinnedServerKey(t *testing.T) {
+	server, err := NewSigner(routing.AuthorityUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := NewSigner(routing.AuthorityUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("turn 3 is over")
+
+	tests := []struct {
+		name   string
+		attack func(*Registry) error
+	}{
+		{
+			name: "announced",
+			attack: func(r *Registry) error {
+				_, err := r.Register(impostor.Announcement())
+				return err
+			},
+		},
+		{
+			name: "in a published registry",
+			attack: func(r *Registry) error {
+				r.Replace(routing.KeyRegistry{Keys: []routing.PublicKeyAnnouncement{impostor.Announcement()}})
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			err := r.Pin(server.Announcement())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = tt.attack(r)
+			err = r.Verify(routing.AuthorityUsername, body, impostor.Sign(body))
+			if err == nil {
+				t.Error("the impostor's signature verified")
+			}
+			err = r.Verify(routing.AuthorityUsername, body, server.Sign(body))
+			if err != nil {
+				t.Errorf("the pinned key no longer verifies: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	alice, err := NewSigner("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSigner("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewSigner(routing.AuthorityUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		announce  routing.PublicKeyAnnouncement
+		wantAdded bool
+		wantErr   bool
+	}{
+		{name: "the same key again", announce: alice.Announcement()},
+		{name: "a different key", announce: other.Announcement(), wantErr: true},
+		{name: "the server's name", announce: server.Announcement(), wantErr: true},
+		{name: "a short key", announce: routing.PublicKeyAnnouncement{Username: "bob", SigningKey: []byte{1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			added, err := r.Register(alice.Announcement())
+			if err != nil || !added {
+				t.Fatalf("first registration: added %v, err %v", added, err)
+			}
+			added, err = r.Register(tt.announce)
+			if (err != nil) != tt.wantErr || added != tt.wantAdded {
+				t.Errorf("added %v, err %v", added, err)
+			}
+		})
+	}
+}
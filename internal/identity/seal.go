@@ -0,0 +1,145 @@
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Seal encrypts text for the recipient's registered X25519 key. A fresh
+// ephemeral key is used per message, so only the recipient can derive the
+// AES-GCM key.
+func (r *Registry) Seal(from, to, text string) (routing.Whisper, error) {
+	eph, nonce, ciphertext, err := r.seal(to, []byte(text), whisperAD(from, to))
+	if err != nil {
+		return routing.Whisper{}, err
+	}
+	return routing.Whisper{
+		From:         from,
+		To:           to,
+		EphemeralKey: eph,
+		Nonce:        nonce,
+		Ciphertext:   ciphertext,
+	}, nil
+}
+
+// SealFor encrypts body for to the same way, bound to the routing key it is
+// published on.
+func (r *Registry) SealFor(to, key string, body []byte) (routing.Sealed, error) {
+	eph, nonce, ciphertext, err := r.seal(to, body, sealedAD(key, to))
+	if err != nil {
+		return routing.Sealed{}, err
+	}
+	return routing.Sealed{
+		To:           to,
+		Key:          key,
+		EphemeralKey: eph,
+		Nonce:        nonce,
+		Ciphertext:   ciphertext,
+	}, nil
+}
+
+func (r *Registry) seal(to string, plaintext, ad []byte) (eph, nonce, ciphertext []byte, err error) {
+	r.mu.RLock()
+	k, ok := r.keys[to]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("no encryption key registered for %s", to)
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(k.EncryptionKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ephKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	shared, err := ephKey.ECDH(recipient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	eph = ephKey.PublicKey().Bytes()
+	aead, err := sealCipher(shared, eph, recipient.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return eph, nonce, aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+// Open decrypts a whisper sent to s.
+func (s *Signer) Open(w routing.Whisper) (string, error) {
+	if w.To != s.Username {
+		return "", fmt.Errorf("whisper is for %s, not %s", w.To, s.Username)
+	}
+	text, err := s.open(w.EphemeralKey, w.Nonce, w.Ciphertext, whisperAD(w.From, w.To))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt whisper from %s: %v", w.From, err)
+	}
+	return string(text), nil
+}
+
+// OpenSealed decrypts a payload sealed for s.
+func (s *Signer) OpenSealed(sd routing.Sealed) ([]byte, error) {
+	if sd.To != s.Username {
+		return nil, fmt.Errorf("%s is sealed for %s, not %s", sd.Key, sd.To, s.Username)
+	}
+	body, err := s.open(sd.EphemeralKey, sd.Nonce, sd.Ciphertext, sealedAD(sd.Key, sd.To))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %v", sd.Key, err)
+	}
+	return body, nil
+}
+
+func (s *Signer) open(eph, nonce, ciphertext, ad []byte) ([]byte, error) {
+	ephKey, err := ecdh.X25519().NewPublicKey(eph)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := s.box.ECDH(ephKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealCipher(shared, eph, s.box.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("nonce has the wrong size: %v", len(nonce))
+	}
+	return aead.Open(nil, nonce, ciphertext, ad)
+}
+
+func sealCipher(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// whisperAD binds the ciphertext to its sender and recipient so it can not be
+// replayed under different names.
+func whisperAD(from, to string) []byte {
+	return []byte(from + "->" + to)
+}
+
+// sealedAD binds the ciphertext to its routing key and recipient.
+func sealedAD(key, to string) []byte {
+	return []byte(key + "=>" + to)
+}
@@ -0,0 +1,129 @@
+package identity
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// testSigners returns a signer per username and a registry that knows them.
+func testSigners(t *testing.T, usernames ...string) (*Registry, map[string]*Signer) {
+	t.Helper()
+	r := NewRegistry()
+	signers := map[string]*Signer{}
+	for _, name := range usernames {
+		s, err := NewSigner(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Register(s.Announcement())
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[name] = s
+	}
+	return r, signers
+}
+
+func TestOpenSealed(t *testing.T) {
+	r, signers := testSigners(t, "alice", "bob")
+	body := []byte(`{"Turn":3}`)
+	key := "turn_results.alice"
+
+	tests := []struct {
+		name    string
+		opener  string
+		change  func(*routing.Sealed)
+		wantErr bool
+	}{
+		{name: "by the recipient", opener: "alice"},
+		{name: "by someone else", opener: "bob", wantErr: true},
+		{
+			name:    "readdressed to someone else",
+			opener:  "bob",
+			change:  func(sd *routing.Sealed) { sd.To = "bob" },
+			wantErr: true,
+		},
+		{
+			name:    "moved to another routing key",
+			opener:  "alice",
+			change:  func(sd *routing.Sealed) { sd.Key = "turn_results.bob" },
+			wantErr: true,
+		},
+		{
+			name:    "tampered ciphertext",
+			opener:  "alice",
+			change:  func(sd *routing.Sealed) { sd.Ciphertext[0] ^= 1 },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd, err := r.SealFor("alice", key, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.change != nil {
+				tt.change(&sd)
+			}
+			got, err := signers[tt.opener].OpenSealed(sd)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("opened %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("got %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestOpenWhisper(t *testing.T) {
+	r, signers := testSigners(t, "alice", "bob", "carol")
+
+	tests := []struct {
+		name    string
+		opener  string
+		change  func(*routing.Whisper)
+		wantErr bool
+	}{
+		{name: "by the recipient", opener: "bob"},
+		{name: "by someone else", opener: "carol", wantErr: true},
+		{
+			name:    "claiming another sender",
+			opener:  "bob",
+			change:  func(w *routing.Whisper) { w.From = "carol" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := r.Seal("alice", "bob", "meet in asia")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.change != nil {
+				tt.change(&w)
+			}
+			got, err := signers[tt.opener].Open(w)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("opened %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "meet in asia" {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
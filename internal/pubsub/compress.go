@@ -0,0 +1,161 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionSnappy
+)
+
+// DefaultCompressionThreshold is the body size, in bytes, below which
+// compressing is not worth the CPU.
+const DefaultCompressionThreshold = 1024
+
+// MaxDecompressedSize is the largest body, in bytes, a delivery may expand
+// to. Anything bigger is refused, so a small compressed message can not make
+// a consumer run out of memory.
+const MaxDecompressedSize = 16 << 20
+
+const (
+	encodingGzip   = "gzip"
+	encodingZstd   = "zstd"
+	encodingSnappy = "snappy"
+)
+
+var compression = struct {
+	mu        *sync.RWMutex
+	algorithm int
+	threshold int
+}{
+	mu:        &sync.RWMutex{},
+	algorithm: CompressionNone,
+	threshold: DefaultCompressionThreshold,
+}
+
+// The zstd encoder and decoder are safe for concurrent use, so they are made
+// once, the first time they are needed.
+var zstdCodec = struct {
+	once    *sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}{
+	once: &sync.Once{},
+}
+
+func zstdInit() error {
+	zstdCodec.once.Do(func() {
+		zstdCodec.encoder, zstdCodec.err = zstd.NewWriter(nil)
+		if zstdCodec.err != nil {
+			return
+		}
+		zstdCodec.decoder, zstdCodec.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	})
+	return zstdCodec.err
+}
+
+// SetCompression makes PublishJSON and PublishGob compress bodies of at least
+// threshold bytes with algorithm. Consumers decompress based on the
+// ContentEncoding of each delivery, so they need no configuration.
+func SetCompression(algorithm, threshold int) error {
+	switch algorithm {
+	case CompressionNone, CompressionGzip, CompressionZstd, CompressionSnappy:
+	default:
+		return fmt.Errorf("given compression is not supported: %v", algorithm)
+	}
+	compression.mu.Lock()
+	defer compression.mu.Unlock()
+	compression.algorithm = algorithm
+	compression.threshold = threshold
+	return nil
+}
+
+// compress returns the body to publish along with its ContentEncoding, which
+// is empty when the body was left as is.
+func compress(body []byte) ([]byte, string, error) {
+	compression.mu.RLock()
+	algorithm := compression.algorithm
+	threshold := compression.threshold
+	compression.mu.RUnlock()
+
+	if len(body) < threshold {
+		return body, "", nil
+	}
+
+	switch algorithm {
+	case CompressionGzip:
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(body)
+		if err != nil {
+			return nil, "", err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), encodingGzip, nil
+	case CompressionZstd:
+		err := zstdInit()
+		if err != nil {
+			return nil, "", err
+		}
+		return zstdCodec.encoder.EncodeAll(body, nil), encodingZstd, nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, body), encodingSnappy, nil
+	default:
+		return body, "", nil
+	}
+}
+
+// decompress expands a delivery's body, refusing any that would grow past
+// MaxDecompressedSize.
+func decompress(body []byte, encoding string) ([]byte, error) {
+	tooBig := fmt.Errorf("body expands to more than %d bytes", MaxDecompressedSize)
+	switch encoding {
+	case "":
+		return body, nil
+	case encodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > MaxDecompressedSize {
+			return nil, tooBig
+		}
+		return out, nil
+	case encodingZstd:
+		err := zstdInit()
+		if err != nil {
+			return nil, err
+		}
+		return zstdCodec.decoder.DecodeAll(body, nil)
+	case encodingSnappy:
+		n, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if n > MaxDecompressedSize {
+			return nil, tooBig
+		}
+		return snappy.Decode(nil, body)
+	default:
+		return nil, fmt.Errorf("given content encoding is not supported: %q", encoding)
+	}
+}
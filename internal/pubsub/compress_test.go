@@ -0,0 +1,65 @@
+package pubsub
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	t.Cleanup(func() {
+		SetCompression(CompressionNone, DefaultCompressionThreshold)
+	})
+
+	tests := []struct {
+		name      string
+		algorithm int
+		size      int
+		wantErr   bool
+	}{
+		{name: "gzip", algorithm: CompressionGzip, size: 64 << 10},
+		{name: "gzip at the cap", algorithm: CompressionGzip, size: MaxDecompressedSize},
+		{name: "gzip over the cap", algorithm: CompressionGzip, size: MaxDecompressedSize + 1, wantErr: true},
+		{name: "zstd", algorithm: CompressionZstd, size: 64 << 10},
+		{name: "zstd over the cap", algorithm: CompressionZstd, size: MaxDecompressedSize + 1, wantErr: true},
+		{name: "snappy", algorithm: CompressionSnappy, size: 64 << 10},
+		{name: "snappy over the cap", algorithm: CompressionSnappy, size: MaxDecompressedSize + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetCompression(tt.algorithm, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Zeros compress to almost nothing, which is what makes them
+			// dangerous to expand.
+			body := make([]byte, tt.size)
+			compressed, encoding, err := compress(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding == "" {
+				t.Fatal("body was not compressed")
+			}
+			got, err := decompress(compressed, encoding)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expanded to %d bytes, want an error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("got %d bytes back, want %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestDecompressUnknownEncoding(t *testing.T) {
+	_, err := decompress([]byte("hello"), "brotli")
+	if err == nil {
+		t.Error("decompressed an unknown encoding")
+	}
+}
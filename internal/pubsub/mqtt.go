@@ -0,0 +1,219 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MQTTClient is the slice of an MQTT client the bridge needs. It is satisfied
+// by a thin adapter over a real client library or by MemoryMQTTBroker.
+type MQTTClient interface {
+	Publish(topic string, payload []byte) error
+	Subscribe(filter string, handler func(topic string, payload []byte)) error
+}
+
+// MQTTBridge copies per-player traffic from peril_topic onto MQTT topics and
+// lets MQTT clients publish orders, turn submissions and heartbeats back into
+// RabbitMQ. Clients sign what they publish themselves (see
+// routing.SignedMessage); the bridge has no key of its own to vouch for them.
+// A bridge serves a single game, and its MQTT topics leave the game ID out.
+type MQTTBridge struct {
+	conn    *amqp.Connection
+	client  MQTTClient
+	gameID  string
+	publish func(key string, body []byte, headers amqp.Table) error
+}
+
+func NewMQTTBridge(conn *amqp.Connection, client MQTTClient, gameID string) (*MQTTBridge, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return &MQTTBridge{
+		conn:   conn,
+		client: client,
+		gameID: gameID,
+		publish: func(key string, body []byte, headers amqp.Table) error {
+			return publishJSON(ch, routing.ExchangePerilTopic, key, body, headers)
+		},
+	}, nil
+}
+
+func (b *MQTTBridge) Start() error {
+	err := SubscribeJSON(b.conn, routing.ExchangePerilTopic, "mqtt_bridge."+routing.GameKey(b.gameID, routing.TurnResultsPrefix), routing.GameKey(b.gameID, routing.TurnResultsPrefix+".*"), int(amqp.Transient),
+		forwardToMQTT(b, func(sealed routing.Sealed) string {
+			return routing.TurnResultsPrefix + "." + sealed.To
+		}), UnmarshallerSealed())
+	if err != nil {
+		return err
+	}
+	err = SubscribeJSON(b.conn, routing.ExchangePerilTopic, "mqtt_bridge."+routing.GameKey(b.gameID, routing.StateDeltasPrefix), routing.GameKey(b.gameID, routing.StateDeltasPrefix+".*"), int(amqp.Transient),
+		forwardToMQTT(b, func(sealed routing.Sealed) string {
+			return routing.StateDeltasPrefix + "." + sealed.To
+		}), UnmarshallerSealed())
+	if err != nil {
+		return err
+	}
+	err = SubscribeJSON(b.conn, routing.ExchangePerilTopic, "mqtt_bridge."+routing.GameKey(b.gameID, routing.TreatiesPrefix), routing.GameKey(b.gameID, routing.TreatiesPrefix+".*"), int(amqp.Transient),
+		forwardToMQTT(b, func(tu gamelogic.TreatyUpdate) string {
+			return routing.TreatiesPrefix + "." + tu.Username
+		}), UnmarshallerTreatyUpdate())
+	if err != nil {
+		return err
+	}
+	err = SubscribeJSON(b.conn, routing.ExchangePerilTopic, "mqtt_bridge."+routing.GameKey(b.gameID, routing.BattleWarningsPrefix), routing.GameKey(b.gameID, routing.BattleWarningsPrefix+".*"), int(amqp.Transient),
+		forwardToMQTT(b, func(sealed routing.Sealed) string {
+			return routing.BattleWarningsPrefix + "." + sealed.To
+		}), UnmarshallerSealed())
+	if err != nil {
+		return err
+	}
+	err = SubscribeGob(b.conn, routing.ExchangePerilTopic, "mqtt_bridge."+routing.GameKey(b.gameID, routing.GameLogSlug), routing.GameKey(b.gameID, routing.GameLogSlug+".*"), int(amqp.Transient),
+		forwardToMQTT(b, func(gl routing.GameLog) string {
+			return routing.GameLogSlug + "." + gl.Username
+		}), UnmarshallerGameLog())
+	if err != nil {
+		return err
+	}
+	return b.subscribeMQTT()
+}
+
+// subscribeMQTT forwards what MQTT clients publish into RabbitMQ.
+func (b *MQTTBridge) subscribeMQTT() error {
+	err := b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.SpawnOrdersPrefix+".*"),
+		forwardToAMQP(b, func(so gamelogic.SpawnOrder) string { return so.Username }, UnmarshallerSpawnOrder()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.PromotionOrdersPrefix+".*"),
+		forwardToAMQP(b, func(po gamelogic.PromotionOrder) string { return po.Username }, UnmarshallerPromotionOrder()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.MoveOrdersPrefix+".*"),
+		forwardToAMQP(b, func(move gamelogic.ArmyMove) string { return move.Player.Username }, UnmarshallerMove()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.DiplomaticActionsPrefix+".*"),
+		forwardToAMQP(b, func(da gamelogic.DiplomaticAction) string { return da.From }, UnmarshallerDiplomaticAction()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.BattleChoicesPrefix+".*"),
+		forwardToAMQP(b, func(bc gamelogic.BattleChoice) string { return bc.Username }, UnmarshallerBattleChoice()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.TurnSubmissionsPrefix+".*"),
+		forwardToAMQP(b, func(ts gamelogic.TurnSubmission) string { return ts.Username }, UnmarshallerTurnSubmission()))
+	if err != nil {
+		return err
+	}
+	err = b.client.Subscribe(routing.ToMQTTTopic(routing.MQTTPublishRoot, routing.PresencePrefix+".*"),
+		forwardToAMQP(b, func(p gamelogic.Presence) string { return p.Username }, UnmarshallerPresence()))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// forwardToMQTT re-encodes a delivery as JSON, which every MQTT library can
+// read, and publishes it on the topic matching its routing key. A player's
+// own view of the game stays sealed, and only they can open it.
+func forwardToMQTT[T any](b *MQTTBridge, keyFor func(T) string) func(T, *amqp.Channel) int {
+	return func(val T, _ *amqp.Channel) int {
+		payload, err := json.Marshal(val)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not encode for mqtt: %v", err))
+			return NackDiscard
+		}
+		err = b.client.Publish(routing.ToMQTTTopic(routing.MQTTTopicRoot, keyFor(val)), payload)
+		if err != nil {
+			fmt.Println(fmt.Errorf("mqtt publish failed: %v", err))
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+// forwardToAMQP publishes a signed message from an MQTT client onto
+// peril_topic, body and signature untouched. The username in the topic has to
+// match the player the payload claims to be, and the signature has to be
+// theirs; the server checks it again on delivery.
+func forwardToAMQP[T any](b *MQTTBridge, claimant func(T) string, unmarshaller func([]byte, int) (T, error)) func(string, []byte) {
+	return func(topic string, payload []byte) {
+		key, ok := routing.FromMQTTTopic(routing.MQTTPublishRoot, topic)
+		if !ok {
+			fmt.Printf("ignoring mqtt topic %s\n", topic)
+			return
+		}
+		sm := routing.SignedMessage{}
+		err := json.Unmarshal(payload, &sm)
+		if err != nil {
+			fmt.Println(fmt.Errorf("bad payload on %s: %v", topic, err))
+			return
+		}
+		val, err := unmarshaller(sm.Body, JSON)
+		if err != nil {
+			fmt.Println(fmt.Errorf("bad payload on %s: %v", topic, err))
+			return
+		}
+		if claimant(val) != routing.KeySuffix(key) {
+			fmt.Printf("ignoring message on %s sent on behalf of %s\n", topic, claimant(val))
+			return
+		}
+		headers := signedBy(sm.Signer, sm.Signature)
+		err = verify(val, sm.Body, headers)
+		if err != nil {
+			fmt.Println(fmt.Errorf("ignoring message on %s: %v", topic, err))
+			return
+		}
+		err = b.publish(routing.GameKey(b.gameID, key), sm.Body, headers)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not publish %s: %v", key, err))
+		}
+	}
+}
+
+// MemoryMQTTBroker is an in-process stand-in for an MQTT broker. Publishing
+// delivers synchronously to every matching subscription.
+type MemoryMQTTBroker struct {
+	mu   *sync.RWMutex
+	subs []memorySubscription
+}
+
+type memorySubscription struct {
+	filter  string
+	handler func(topic string, payload []byte)
+}
+
+func NewMemoryMQTTBroker() *MemoryMQTTBroker {
+	return &MemoryMQTTBroker{
+		mu: &sync.RWMutex{},
+	}
+}
+
+func (mb *MemoryMQTTBroker) Publish(topic string, payload []byte) error {
+	mb.mu.RLock()
+	subs := append([]memorySubscription{}, mb.subs...)
+	mb.mu.RUnlock()
+	for _, s := range subs {
+		if routing.MatchMQTTTopic(s.filter, topic) {
+			s.handler(topic, payload)
+		}
+	}
+	return nil
+}
+
+func (mb *MemoryMQTTBroker) Subscribe(filter string, handler func(topic string, payload []byte)) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.subs = append(mb.subs, memorySubscription{filter: filter, handler: handler})
+	return nil
+}
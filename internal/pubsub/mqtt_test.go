@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// published is a message the bridge sent on to RabbitMQ.
+type published struct {
+	key     string
+	body    []byte
+	headers amqp.Table
+}
+
+// testBridge returns a bridge for gameID on an in-memory broker that records
+// what it would publish to RabbitMQ instead.
+func testBridge(gameID string) (*MQTTBridge, *MemoryMQTTBroker, *[]published) {
+	broker := NewMemoryMQTTBroker()
+	sent := &[]published{}
+	b := &MQTTBridge{
+		client: broker,
+		gameID: gameID,
+		publish: func(key string, body []byte, headers amqp.Table) error {
+			*sent = append(*sent, published{key: key, body: body, headers: headers})
+			return nil
+		},
+	}
+	return b, broker, sent
+}
+
+// signedMessage is what an MQTT client publishes: val signed by s.
+func signedMessage(t *testing.T, s *identity.Signer, val any) []byte {
+	t.Helper()
+	body, err := json.Marshal(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(routing.SignedMessage{
+		Signer:    s.Username,
+		Signature: s.Sign(body),
+		Body:      body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestBridgeToAMQP(t *testing.T) {
+	_, signers := testPlayers(t, "alice", "bob")
+	submission := gamelogic.TurnSubmission{Username: "alice", Turn: 3}
+
+	tests := []struct {
+		name    string
+		gameID  string
+		topic   string
+		payload []byte
+		want    string
+	}{
+		{
+			name:    "default game",
+			topic:   "peril-in/turn_submissions/alice",
+			payload: signedMessage(t, signers["alice"], submission),
+			want:    "turn_submissions.alice",
+		},
+		{
+			name:    "named game",
+			gameID:  "friday",
+			topic:   "peril-in/turn_submissions/alice",
+			payload: signedMessage(t, signers["alice"], submission),
+			want:    "game.friday.turn_submissions.alice",
+		},
+		{
+			name:    "on someone else's topic",
+			topic:   "peril-in/turn_submissions/bob",
+			payload: signedMessage(t, signers["alice"], submission),
+		},
+		{
+			name:    "signed by someone else",
+			topic:   "peril-in/turn_submissions/alice",
+			payload: signedMessage(t, signers["bob"], submission),
+		},
+		{
+			name:    "not signed",
+			topic:   "peril-in/turn_submissions/alice",
+			payload: []byte(`{"Body":{"Username":"alice","Turn":3}}`),
+		},
+		{
+			name:    "outside the publish root",
+			topic:   "peril/turn_submissions/alice",
+			payload: signedMessage(t, signers["alice"], submission),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, broker, sent := testBridge(tt.gameID)
+			err := b.subscribeMQTT()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = broker.Publish(tt.topic, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if len(*sent) != 0 {
+					t.Fatalf("forwarded %d message(s), want none", len(*sent))
+				}
+				return
+			}
+			if len(*sent) != 1 {
+				t.Fatalf("forwarded %d message(s), want 1", len(*sent))
+			}
+			p := (*sent)[0]
+			if p.key != tt.want {
+				t.Errorf("key = %q, want %q", p.key, tt.want)
+			}
+			// The signature is passed on so the server can check it again.
+			err = verify(submission, p.body, p.headers)
+			if err != nil {
+				t.Errorf("forwarded message does not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestBridgeToMQTT(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		to     string
+		want   string
+	}{
+		{name: "own topic", filter: "peril/turn_results/alice", to: "alice", want: "peril/turn_results/alice"},
+		{name: "one level wildcard", filter: "peril/turn_results/+", to: "bob", want: "peril/turn_results/bob"},
+		{name: "every level wildcard", filter: "peril/#", to: "bob", want: "peril/turn_results/bob"},
+		{name: "someone else's topic", filter: "peril/turn_results/alice", to: "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, broker, _ := testBridge("")
+			got := []string{}
+			err := broker.Subscribe(tt.filter, func(topic string, _ []byte) {
+				got = append(got, topic)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			forward := forwardToMQTT(b, func(sealed routing.Sealed) string {
+				return routing.TurnResultsPrefix + "." + sealed.To
+			})
+			ack := forward(routing.Sealed{To: tt.to}, nil)
+			if ack != Ack {
+				t.Fatalf("ack = %v, want Ack", ack)
+			}
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Fatalf("delivered on %v, want nothing", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("delivered on %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -61,10 +63,22 @@ func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	if err != nil {
 		return err
 	}
+	return publishJSON(ch, exchange, key, bytes, sign(val, bytes))
+}
+
+// publishJSON publishes an encoded JSON payload with the signature headers
+// already worked out, which lets the MQTT bridge pass on a client's own.
+func publishJSON(ch *amqp.Channel, exchange, key string, bytes []byte, headers amqp.Table) error {
+	body, encoding, err := compress(bytes)
+	if err != nil {
+		return err
+	}
 
 	pub := amqp.Publishing{
-		ContentType: "application/json",
-		Body:        bytes,
+		ContentType:     "application/json",
+		ContentEncoding: encoding,
+		Headers:         headers,
+		Body:            body,
 	}
 
 	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, pub)
@@ -96,9 +110,16 @@ func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
 		return err
 	}
 
+	body, encoding, err := compress(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
 	pub := amqp.Publishing{
-		ContentType: "application/gob",
-		Body:        buf.Bytes(),
+		ContentType:     "application/gob",
+		ContentEncoding: encoding,
+		Headers:         sign(val, buf.Bytes()),
+		Body:            body,
 	}
 
 	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, pub)
@@ -137,7 +158,47 @@ func subscribe[T any](
 	if err != nil {
 		return err
 	}
-	err = ch.Qos(10, 1000, true)
+	return consume(ch, queueName, handler, unmarshaller, dataType)
+}
+
+// SubscribePrivateJSON consumes from a queue the broker names, that only this
+// connection can read and that nothing is bound to. The default exchange is
+// the only way in, by the queue's name, so it is handed out as the reply
+// address of requests whose answers nobody else should see.
+func SubscribePrivateJSON[T any](
+	conn *amqp.Connection,
+	handler func(T, *amqp.Channel) int,
+	unmarshaller func([]byte, int) (T, error),
+) (string, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return "", err
+	}
+	t := amqp.Table{
+		"x-dead-letter-exchange": "peril_dlx",
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, t)
+	if err != nil {
+		return "", err
+	}
+	err = consume(ch, q.Name, handler, unmarshaller, JSON)
+	if err != nil {
+		fmt.Println(fmt.Errorf("subscribe failed: %v", err))
+		return "", err
+	}
+	return q.Name, nil
+}
+
+// consume hands every delivery on queueName to handler once it has been
+// decompressed, unmarshalled and verified.
+func consume[T any](
+	ch *amqp.Channel,
+	queueName string,
+	handler func(T, *amqp.Channel) int,
+	unmarshaller func([]byte, int) (T, error),
+	dataType int,
+) error {
+	err := ch.Qos(10, 1000, true)
 	if err != nil {
 		return err
 	}
@@ -147,12 +208,7 @@ func subscribe[T any](
 	}
 	go func() {
 		for d := range amqpDelivery {
-			val, err := unmarshaller(d.Body, dataType)
-			if err != nil {
-				fmt.Println(fmt.Errorf("failure to unmarshal in Gob: %v", err))
-			}
-
-			ack := handler(val, ch)
+			ack := deliver(d, ch, handler, unmarshaller, dataType)
 			fmt.Printf("ack: %v", ack)
 			switch ack {
 			case Ack:
@@ -163,10 +219,40 @@ func subscribe[T any](
 				d.Nack(false, false)
 			}
 		}
+		// The queue is gone, or the connection is.
+		ch.Close()
 	}()
 	return nil
 }
 
+// deliver decompresses, unmarshals and verifies a delivery and hands it to
+// handler, returning how it should be acknowledged. A delivery that fails any
+// step before the handler is discarded without the handler ever seeing it.
+func deliver[T any](
+	d amqp.Delivery,
+	ch *amqp.Channel,
+	handler func(T, *amqp.Channel) int,
+	unmarshaller func([]byte, int) (T, error),
+	dataType int,
+) int {
+	body, err := decompress(d.Body, d.ContentEncoding)
+	if err != nil {
+		fmt.Println(fmt.Errorf("failure to decompress: %v", err))
+		return NackDiscard
+	}
+	val, err := unmarshaller(body, dataType)
+	if err != nil {
+		fmt.Println(fmt.Errorf("failure to unmarshal: %v", err))
+		return NackDiscard
+	}
+	err = verify(val, body, d.Headers)
+	if err != nil {
+		fmt.Println(fmt.Errorf("rejecting delivery: %v", err))
+		return NackDiscard
+	}
+	return handler(val, ch)
+}
+
 func UnmarshallerPlayingState() func([]byte, int) (routing.PlayingState, error) {
 	return func(arr []byte, dataType int) (routing.PlayingState, error) {
 		var ps routing.PlayingState
@@ -219,153 +305,974 @@ func UnmarshallerMove() func([]byte, int) (gamelogic.ArmyMove, error) {
 	}
 }
 
-func UnmarshallerWar() func([]byte, int) (gamelogic.RecognitionOfWar, error) {
-	return func(arr []byte, dataType int) (gamelogic.RecognitionOfWar, error) {
-		var row gamelogic.RecognitionOfWar
-		rowp := &row
+func UnmarshallerGameLog() func([]byte, int) (routing.GameLog, error) {
+	return func(arr []byte, dataType int) (routing.GameLog, error) {
+		var gl routing.GameLog
+		glp := &gl
 		switch dataType {
 		case JSON:
-			err := json.Unmarshal(arr, rowp)
+			err := json.Unmarshal(arr, glp)
 			if err != nil {
-				return row, fmt.Errorf("error unmarshalling delivery body: %v", err)
+				return gl, fmt.Errorf("error unmarshalling delivery body: %v", err)
 			}
-			return row, nil
+			return gl, nil
 
 		case GOB:
 			b := bytes.NewBuffer(arr)
-			err := gob.NewDecoder(b).Decode(rowp)
+			err := gob.NewDecoder(b).Decode(glp)
 			if err != nil {
-				return row, fmt.Errorf("decoding failed: %v", err)
+				return gl, fmt.Errorf("decoding failed: %v", err)
 			}
-			return row, nil
+			return gl, nil
 
 		default:
-			return row, fmt.Errorf("given dataType is not supported: %q", dataType)
+			return gl, fmt.Errorf("given dataType is not supported: %q", dataType)
 		}
 	}
 }
 
-func UnmarshallerGameLog() func([]byte, int) (routing.GameLog, error) {
-	return func(arr []byte, dataType int) (routing.GameLog, error) {
-		var gl routing.GameLog
-		glp := &gl
+func UnmarshallerKeyAnnouncement() func([]byte, int) (routing.PublicKeyAnnouncement, error) {
+	return func(arr []byte, dataType int) (routing.PublicKeyAnnouncement, error) {
+		var pka routing.PublicKeyAnnouncement
+		pkap := &pka
 		switch dataType {
 		case JSON:
-			err := json.Unmarshal(arr, glp)
+			err := json.Unmarshal(arr, pkap)
 			if err != nil {
-				return gl, fmt.Errorf("error unmarshalling delivery body: %v", err)
+				return pka, fmt.Errorf("error unmarshalling delivery body: %v", err)
 			}
-			return gl, nil
+			return pka, nil
 
 		case GOB:
 			b := bytes.NewBuffer(arr)
-			err := gob.NewDecoder(b).Decode(glp)
+			err := gob.NewDecoder(b).Decode(pkap)
 			if err != nil {
-				return gl, fmt.Errorf("decoding failed: %v", err)
+				return pka, fmt.Errorf("decoding failed: %v", err)
 			}
-			return gl, nil
+			return pka, nil
 
 		default:
-			return gl, fmt.Errorf("given dataType is not supported: %q", dataType)
+			return pka, fmt.Errorf("given dataType is not supported: %q", dataType)
 		}
 	}
 }
 
-func HandlerPause(gs *gamelogic.GameState) func(routing.PlayingState, *amqp.Channel) int {
-	return func(ps routing.PlayingState, _ *amqp.Channel) int {
-		defer fmt.Print("> ")
-		gs.HandlePause(ps)
-		return Ack
+func UnmarshallerKeyRegistry() func([]byte, int) (routing.KeyRegistry, error) {
+	return func(arr []byte, dataType int) (routing.KeyRegistry, error) {
+		var kr routing.KeyRegistry
+		krp := &kr
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, krp)
+			if err != nil {
+				return kr, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return kr, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(krp)
+			if err != nil {
+				return kr, fmt.Errorf("decoding failed: %v", err)
+			}
+			return kr, nil
+
+		default:
+			return kr, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
 	}
 }
 
-func HandlerMoves(gs *gamelogic.GameState) func(gamelogic.ArmyMove, *amqp.Channel) int {
-	return func(move gamelogic.ArmyMove, aCh *amqp.Channel) int {
-		defer fmt.Print("> ")
-		mo := gs.HandleMove(move)
-		switch mo {
-		case gamelogic.MoveOutComeSafe:
-			return Ack
-		case gamelogic.MoveOutcomeMakeWar:
-			row := gamelogic.RecognitionOfWar{
-				Attacker: move.Player,
-				Defender: gs.Player,
+func UnmarshallerWhisper() func([]byte, int) (routing.Whisper, error) {
+	return func(arr []byte, dataType int) (routing.Whisper, error) {
+		var w routing.Whisper
+		wp := &w
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, wp)
+			if err != nil {
+				return w, fmt.Errorf("error unmarshalling delivery body: %v", err)
 			}
-			err := PublishJSON(aCh, routing.ExchangePerilTopic, routing.WarRecognitionsPrefix+"."+gs.GetUsername(), row)
+			return w, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(wp)
 			if err != nil {
-				return NackRequeue
+				return w, fmt.Errorf("decoding failed: %v", err)
 			}
-			return Ack
-		case gamelogic.MoveOutcomeSamePlayer:
-			return NackDiscard
+			return w, nil
+
 		default:
-			return NackDiscard
+			return w, fmt.Errorf("given dataType is not supported: %q", dataType)
 		}
 	}
 }
 
-func HandlerWar(gs *gamelogic.GameState) func(gamelogic.RecognitionOfWar, *amqp.Channel) int {
-	return func(row gamelogic.RecognitionOfWar, aCh *amqp.Channel) int {
-		defer fmt.Print("> ")
-		outcome, winner, loser := gs.HandleWar(row)
-		message := "%s won a war against %s"
-		result := NackDiscard
-		switch outcome {
-		case gamelogic.WarOutcomeNotInvolved:
-			result = NackRequeue
-		case gamelogic.WarOutcomeNoUnits:
-			result = NackDiscard
-		case gamelogic.WarOutcomeOpponentWon:
-			message = fmt.Sprintf(message, winner, loser)
-			gl := routing.GameLog{
-				CurrentTime: time.Now(),
-				Message:     message,
-				Username:    gs.GetUsername(),
-			}
-			err := PublishGob(aCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+row.Attacker.Username, gl)
-			if err != nil {
-				result = NackRequeue
-			} else {
-				result = Ack
-			}
-		case gamelogic.WarOutcomeYouWon:
-			message = fmt.Sprintf(message, winner, loser)
-			gl := routing.GameLog{
-				CurrentTime: time.Now(),
-				Message:     message,
-				Username:    gs.GetUsername(),
-			}
-			err := PublishGob(aCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+row.Attacker.Username, gl)
-			if err != nil {
-				result = NackRequeue
-			} else {
-				result = Ack
-			}
-		case gamelogic.WarOutcomeDraw:
-			message = fmt.Sprintf("A war between %s and %s resulted in a draw", winner, loser)
-			gl := routing.GameLog{
-				CurrentTime: time.Now(),
-				Message:     message,
-				Username:    gs.GetUsername(),
-			}
-			err := PublishGob(aCh, routing.ExchangePerilTopic, routing.GameLogSlug+"."+row.Attacker.Username, gl)
-			if err != nil {
-				result = NackRequeue
-			} else {
-				result = Ack
+func UnmarshallerSpawnOrder() func([]byte, int) (gamelogic.SpawnOrder, error) {
+	return func(arr []byte, dataType int) (gamelogic.SpawnOrder, error) {
+		var so gamelogic.SpawnOrder
+		sop := &so
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, sop)
+			if err != nil {
+				return so, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return so, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(sop)
+			if err != nil {
+				return so, fmt.Errorf("decoding failed: %v", err)
 			}
+			return so, nil
+
 		default:
-			fmt.Println("Error bad outcome of war")
-			result = NackDiscard
+			return so, fmt.Errorf("given dataType is not supported: %q", dataType)
 		}
-		return result
 	}
 }
 
-func HandlerGameLog(_ *gamelogic.GameState) func(routing.GameLog, *amqp.Channel) int {
-	return func(gl routing.GameLog, _ *amqp.Channel) int {
+func UnmarshallerStateDelta() func([]byte, int) (gamelogic.StateDelta, error) {
+	return func(arr []byte, dataType int) (gamelogic.StateDelta, error) {
+		var sd gamelogic.StateDelta
+		sdp := &sd
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, sdp)
+			if err != nil {
+				return sd, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return sd, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(sdp)
+			if err != nil {
+				return sd, fmt.Errorf("decoding failed: %v", err)
+			}
+			return sd, nil
+
+		default:
+			return sd, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerSealed() func([]byte, int) (routing.Sealed, error) {
+	return func(arr []byte, dataType int) (routing.Sealed, error) {
+		var s routing.Sealed
+		sp := &s
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, sp)
+			if err != nil {
+				return s, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return s, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(sp)
+			if err != nil {
+				return s, fmt.Errorf("decoding failed: %v", err)
+			}
+			return s, nil
+
+		default:
+			return s, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerRuleset() func([]byte, int) (gamelogic.Ruleset, error) {
+	return func(arr []byte, dataType int) (gamelogic.Ruleset, error) {
+		var r gamelogic.Ruleset
+		rp := &r
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, rp)
+			if err != nil {
+				return r, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return r, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(rp)
+			if err != nil {
+				return r, fmt.Errorf("decoding failed: %v", err)
+			}
+			return r, nil
+
+		default:
+			return r, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerTurnSubmission() func([]byte, int) (gamelogic.TurnSubmission, error) {
+	return func(arr []byte, dataType int) (gamelogic.TurnSubmission, error) {
+		var ts gamelogic.TurnSubmission
+		tsp := &ts
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, tsp)
+			if err != nil {
+				return ts, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return ts, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(tsp)
+			if err != nil {
+				return ts, fmt.Errorf("decoding failed: %v", err)
+			}
+			return ts, nil
+
+		default:
+			return ts, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerTurnResult() func([]byte, int) (gamelogic.TurnResult, error) {
+	return func(arr []byte, dataType int) (gamelogic.TurnResult, error) {
+		var tr gamelogic.TurnResult
+		trp := &tr
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, trp)
+			if err != nil {
+				return tr, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return tr, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(trp)
+			if err != nil {
+				return tr, fmt.Errorf("decoding failed: %v", err)
+			}
+			return tr, nil
+
+		default:
+			return tr, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerGameOver() func([]byte, int) (gamelogic.GameOver, error) {
+	return func(arr []byte, dataType int) (gamelogic.GameOver, error) {
+		var g gamelogic.GameOver
+		gp := &g
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, gp)
+			if err != nil {
+				return g, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return g, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(gp)
+			if err != nil {
+				return g, fmt.Errorf("decoding failed: %v", err)
+			}
+			return g, nil
+
+		default:
+			return g, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerDiplomaticAction() func([]byte, int) (gamelogic.DiplomaticAction, error) {
+	return func(arr []byte, dataType int) (gamelogic.DiplomaticAction, error) {
+		var da gamelogic.DiplomaticAction
+		dap := &da
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, dap)
+			if err != nil {
+				return da, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return da, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(dap)
+			if err != nil {
+				return da, fmt.Errorf("decoding failed: %v", err)
+			}
+			return da, nil
+
+		default:
+			return da, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerTreatyUpdate() func([]byte, int) (gamelogic.TreatyUpdate, error) {
+	return func(arr []byte, dataType int) (gamelogic.TreatyUpdate, error) {
+		var tu gamelogic.TreatyUpdate
+		tup := &tu
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, tup)
+			if err != nil {
+				return tu, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return tu, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(tup)
+			if err != nil {
+				return tu, fmt.Errorf("decoding failed: %v", err)
+			}
+			return tu, nil
+
+		default:
+			return tu, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerBattleWarning() func([]byte, int) (gamelogic.BattleWarning, error) {
+	return func(arr []byte, dataType int) (gamelogic.BattleWarning, error) {
+		var bw gamelogic.BattleWarning
+		bwp := &bw
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, bwp)
+			if err != nil {
+				return bw, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return bw, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(bwp)
+			if err != nil {
+				return bw, fmt.Errorf("decoding failed: %v", err)
+			}
+			return bw, nil
+
+		default:
+			return bw, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerBattleChoice() func([]byte, int) (gamelogic.BattleChoice, error) {
+	return func(arr []byte, dataType int) (gamelogic.BattleChoice, error) {
+		var bc gamelogic.BattleChoice
+		bcp := &bc
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, bcp)
+			if err != nil {
+				return bc, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return bc, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(bcp)
+			if err != nil {
+				return bc, fmt.Errorf("decoding failed: %v", err)
+			}
+			return bc, nil
+
+		default:
+			return bc, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerPromotionOrder() func([]byte, int) (gamelogic.PromotionOrder, error) {
+	return func(arr []byte, dataType int) (gamelogic.PromotionOrder, error) {
+		var po gamelogic.PromotionOrder
+		pop := &po
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, pop)
+			if err != nil {
+				return po, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return po, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(pop)
+			if err != nil {
+				return po, fmt.Errorf("decoding failed: %v", err)
+			}
+			return po, nil
+
+		default:
+			return po, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerLobbyRequest() func([]byte, int) (gamelogic.LobbyRequest, error) {
+	return func(arr []byte, dataType int) (gamelogic.LobbyRequest, error) {
+		var lr gamelogic.LobbyRequest
+		lrp := &lr
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, lrp)
+			if err != nil {
+				return lr, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return lr, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(lrp)
+			if err != nil {
+				return lr, fmt.Errorf("decoding failed: %v", err)
+			}
+			return lr, nil
+
+		default:
+			return lr, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerLobbyReply() func([]byte, int) (gamelogic.LobbyReply, error) {
+	return func(arr []byte, dataType int) (gamelogic.LobbyReply, error) {
+		var lr gamelogic.LobbyReply
+		lrp := &lr
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, lrp)
+			if err != nil {
+				return lr, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return lr, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(lrp)
+			if err != nil {
+				return lr, fmt.Errorf("decoding failed: %v", err)
+			}
+			return lr, nil
+
+		default:
+			return lr, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerPresence() func([]byte, int) (gamelogic.Presence, error) {
+	return func(arr []byte, dataType int) (gamelogic.Presence, error) {
+		var p gamelogic.Presence
+		pp := &p
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, pp)
+			if err != nil {
+				return p, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return p, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(pp)
+			if err != nil {
+				return p, fmt.Errorf("decoding failed: %v", err)
+			}
+			return p, nil
+
+		default:
+			return p, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerPlayerPresence() func([]byte, int) (gamelogic.PlayerPresence, error) {
+	return func(arr []byte, dataType int) (gamelogic.PlayerPresence, error) {
+		var pp gamelogic.PlayerPresence
+		ppp := &pp
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, ppp)
+			if err != nil {
+				return pp, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return pp, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(ppp)
+			if err != nil {
+				return pp, fmt.Errorf("decoding failed: %v", err)
+			}
+			return pp, nil
+
+		default:
+			return pp, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerWarSummary() func([]byte, int) (gamelogic.WarSummary, error) {
+	return func(arr []byte, dataType int) (gamelogic.WarSummary, error) {
+		var ws gamelogic.WarSummary
+		wsp := &ws
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, wsp)
+			if err != nil {
+				return ws, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return ws, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(wsp)
+			if err != nil {
+				return ws, fmt.Errorf("decoding failed: %v", err)
+			}
+			return ws, nil
+
+		default:
+			return ws, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func UnmarshallerWorldSummary() func([]byte, int) (gamelogic.WorldSummary, error) {
+	return func(arr []byte, dataType int) (gamelogic.WorldSummary, error) {
+		var ws gamelogic.WorldSummary
+		wsp := &ws
+		switch dataType {
+		case JSON:
+			err := json.Unmarshal(arr, wsp)
+			if err != nil {
+				return ws, fmt.Errorf("error unmarshalling delivery body: %v", err)
+			}
+			return ws, nil
+
+		case GOB:
+			b := bytes.NewBuffer(arr)
+			err := gob.NewDecoder(b).Decode(wsp)
+			if err != nil {
+				return ws, fmt.Errorf("decoding failed: %v", err)
+			}
+			return ws, nil
+
+		default:
+			return ws, fmt.Errorf("given dataType is not supported: %q", dataType)
+		}
+	}
+}
+
+func HandlerPause(gs *gamelogic.GameState) func(routing.PlayingState, *amqp.Channel) int {
+	return func(ps routing.PlayingState, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandlePause(ps)
+		return Ack
+	}
+}
+
+func HandlerRuleset(gs *gamelogic.GameState) func(gamelogic.Ruleset, *amqp.Channel) int {
+	return func(r gamelogic.Ruleset, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		err := gs.HandleRuleset(r)
+		if err != nil {
+			return NackDiscard
+		}
+		return Ack
+	}
+}
+
+func HandlerStateDelta(gs *gamelogic.GameState) func(gamelogic.StateDelta, *amqp.Channel) int {
+	return func(sd gamelogic.StateDelta, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandleStateDelta(sd)
+		return Ack
+	}
+}
+
+// HandlerSpawnOrder queues a spawn for the end of the turn, or resets the
+// player to the canonical army if it is refused.
+func HandlerSpawnOrder(w *gamelogic.World) func(gamelogic.SpawnOrder, *amqp.Channel) int {
+	return func(so gamelogic.SpawnOrder, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		err := w.QueueSpawn(so)
+		if err == nil {
+			return Ack
+		}
+		fmt.Printf("rejected spawn from %s: %v\n", so.Username, err)
+		err = PublishSealedJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.StateDeltasPrefix+"."+so.Username), so.Username, w.Reject(so.Username, err))
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+// HandlerPromotionOrder queues a promotion for the end of the turn, or resets
+// the player to the canonical army if it is refused.
+func HandlerPromotionOrder(w *gamelogic.World) func(gamelogic.PromotionOrder, *amqp.Channel) int {
+	return func(po gamelogic.PromotionOrder, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		err := w.QueuePromotion(po)
+		if err == nil {
+			return Ack
+		}
+		fmt.Printf("rejected promotion from %s: %v\n", po.Username, err)
+		err = PublishSealedJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.StateDeltasPrefix+"."+po.Username), po.Username, w.Reject(po.Username, err))
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+// HandlerMoveOrder queues a move for the end of the turn, or resets the
+// player to the canonical army if it is refused.
+func HandlerMoveOrder(w *gamelogic.World) func(gamelogic.ArmyMove, *amqp.Channel) int {
+	return func(move gamelogic.ArmyMove, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		username := move.Player.Username
+		err := w.QueueMove(move)
+		if err == nil {
+			return Ack
+		}
+		fmt.Printf("rejected move from %s: %v\n", username, err)
+		err = PublishSealedJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.StateDeltasPrefix+"."+username), username, w.Reject(username, err))
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+// HandlerTurnSubmission records that a player is done with the turn. Once
+// everyone is, it signals early so the turn can be resolved without waiting
+// for the clock.
+func HandlerTurnSubmission(w *gamelogic.World, early chan<- struct{}) func(gamelogic.TurnSubmission, *amqp.Channel) int {
+	return func(ts gamelogic.TurnSubmission, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		allSubmitted, err := w.Submit(ts)
+		if err != nil {
+			fmt.Printf("ignoring submission from %s: %v\n", ts.Username, err)
+			return NackDiscard
+		}
+		if allSubmitted {
+			select {
+			case early <- struct{}{}:
+			default:
+			}
+		}
+		return Ack
+	}
+}
+
+// HandlerDiplomaticAction applies a diplomatic action and tells both players
+// how their treaty changed.
+func HandlerDiplomaticAction(w *gamelogic.World) func(gamelogic.DiplomaticAction, *amqp.Channel) int {
+	return func(da gamelogic.DiplomaticAction, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		t, err := w.ApplyDiplomacy(da)
+		if err != nil {
+			fmt.Printf("rejected %s from %s: %v\n", da.Kind, da.From, err)
+			err = PublishSealedJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.StateDeltasPrefix+"."+da.From), da.From, w.Reject(da.From, err))
+			if err != nil {
+				return NackRequeue
+			}
+			return Ack
+		}
+		for _, username := range t.Players {
+			tu := gamelogic.TreatyUpdate{
+				Username: username,
+				Treaty:   t,
+			}
+			err := PublishJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.TreatiesPrefix+"."+username), tu)
+			if err != nil {
+				return NackRequeue
+			}
+		}
+		return Ack
+	}
+}
+
+func HandlerTreatyUpdate(gs *gamelogic.GameState) func(gamelogic.TreatyUpdate, *amqp.Channel) int {
+	return func(tu gamelogic.TreatyUpdate, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandleTreatyUpdate(tu)
+		return Ack
+	}
+}
+
+// HandlerBattleChoice records a defender's answer to a battle warning. Once
+// every defender has answered it signals responded so the battles can be
+// fought without waiting for the deadline.
+func HandlerBattleChoice(w *gamelogic.World, responded chan<- struct{}) func(gamelogic.BattleChoice, *amqp.Channel) int {
+	return func(bc gamelogic.BattleChoice, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		allResponded, err := w.RespondToBattle(bc)
+		if err != nil {
+			fmt.Printf("rejected %s from %s: %v\n", bc.Choice, bc.Username, err)
+			err = PublishSealedJSON(aCh, routing.ExchangePerilTopic, routing.GameKey(w.ID, routing.StateDeltasPrefix+"."+bc.Username), bc.Username, w.Reject(bc.Username, err))
+			if err != nil {
+				return NackRequeue
+			}
+			return Ack
+		}
+		if allResponded {
+			select {
+			case responded <- struct{}{}:
+			default:
+			}
+		}
+		return Ack
+	}
+}
+
+func HandlerBattleWarning(gs *gamelogic.GameState) func(gamelogic.BattleWarning, *amqp.Channel) int {
+	return func(bw gamelogic.BattleWarning, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandleBattleWarning(bw)
+		return Ack
+	}
+}
+
+func HandlerGameOver(gs *gamelogic.GameState) func(gamelogic.GameOver, *amqp.Channel) int {
+	return func(g gamelogic.GameOver, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandleGameOver(g)
+		return Ack
+	}
+}
+
+func HandlerTurnResult(gs *gamelogic.GameState) func(gamelogic.TurnResult, *amqp.Channel) int {
+	return func(tr gamelogic.TurnResult, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandleTurnResult(tr)
+		return Ack
+	}
+}
+
+// HandlerGameLog writes the logs published by world's players to disk.
+func HandlerGameLog(w *gamelogic.World) func(routing.GameLog, *amqp.Channel) int {
+	return func(gl routing.GameLog, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		if !w.HasPlayer(gl.Username) {
+			fmt.Printf("ignoring log from %s, who is not in %s\n", gl.Username, gamelogic.GameName(w.ID))
+			return NackDiscard
+		}
+		gamelogic.WriteLog(w.ID, gl)
+		return Ack
+	}
+}
+
+// HandlerKeyAnnouncement registers a player's public key with the server and
+// republishes the whole registry so every client can verify them.
+func HandlerKeyAnnouncement(r *identity.Registry) func(routing.PublicKeyAnnouncement, *amqp.Channel) int {
+	return func(pka routing.PublicKeyAnnouncement, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		added, err := r.Register(pka)
+		if err != nil {
+			fmt.Println(fmt.Errorf("rejecting key: %v", err))
+			return NackDiscard
+		}
+		if added {
+			fmt.Printf("registered signing key for %s\n", pka.Username)
+		}
+		err = PublishJSON(aCh, routing.ExchangePerilDirect, routing.KeyRegistryKey, r.Snapshot())
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+func HandlerKeyRegistry(r *identity.Registry) func(routing.KeyRegistry, *amqp.Channel) int {
+	return func(kr routing.KeyRegistry, _ *amqp.Channel) int {
+		r.Replace(kr)
+		return Ack
+	}
+}
+
+// HandlerKeyRegistered is HandlerKeyRegistry for a client that has announced
+// its own key. It signals registered once the registry includes username, as
+// the server verifies nothing username signs before then.
+func HandlerKeyRegistered(r *identity.Registry, username string, registered chan<- struct{}) func(routing.KeyRegistry, *amqp.Channel) int {
+	hkr := HandlerKeyRegistry(r)
+	return func(kr routing.KeyRegistry, aCh *amqp.Channel) int {
+		ack := hkr(kr, aCh)
+		for _, a := range kr.Keys {
+			if a.Username != username {
+				continue
+			}
+			select {
+			case registered <- struct{}{}:
+			default:
+			}
+		}
+		return ack
+	}
+}
+
+func HandlerWhisper(gs *gamelogic.GameState, s *identity.Signer) func(routing.Whisper, *amqp.Channel) int {
+	return func(w routing.Whisper, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		text, err := s.Open(w)
+		if err != nil {
+			fmt.Println(err)
+			return NackDiscard
+		}
+		gs.ReceiveWhisper(w.From, text)
+		return Ack
+	}
+}
+
+// HandlerLobbyRequest answers a player's lobby request on the private queue it
+// names.
+// created is called with every new game so the server can start hosting it,
+// and players who join a game are sent its ruleset and whether it is paused.
+// Players who resume get both in their snapshot instead.
+func HandlerLobbyRequest(l *gamelogic.Lobby, created func(*gamelogic.World) error) func(gamelogic.LobbyRequest, *amqp.Channel) int {
+	return func(lr gamelogic.LobbyRequest, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		// Only queues the broker named are private to whoever declared them,
+		// so anything else could hand the reply to someone else or into one
+		// of the server's own queues.
+		if !strings.HasPrefix(lr.ReplyTo, routing.PrivateQueuePrefix) {
+			fmt.Printf("%s did not ask for a reply on a private queue\n", lr.Username)
+			return NackDiscard
+		}
+		reply, w := l.Handle(lr)
+		if reply.Error == "" && lr.Action == gamelogic.LobbyCreate {
+			fmt.Printf("%s created %s\n", lr.Username, gamelogic.GameName(w.ID))
+			err := created(w)
+			if err != nil {
+				fmt.Println(fmt.Errorf("could not start %s: %v", gamelogic.GameName(w.ID), err))
+				reply.Error = "the game could not be started"
+				l.Remove(w.ID)
+			}
+		}
+		if reply.Error == "" && lr.Action == gamelogic.LobbyJoin {
+			fmt.Printf("%s joined %s\n", lr.Username, gamelogic.GameName(w.ID))
+			err := PublishJSON(aCh, routing.ExchangePerilDirect, routing.GameKey(w.ID, routing.RulesetKey), *w.Rules)
+			if err != nil {
+				return NackRequeue
+			}
+			err = PublishJSON(aCh, routing.ExchangePerilDirect, routing.GameKey(w.ID, routing.PauseKey), routing.PlayingState{IsPaused: w.IsPaused()})
+			if err != nil {
+				return NackRequeue
+			}
+		}
+		if reply.Error == "" && lr.Action == gamelogic.LobbyLeave {
+			fmt.Printf("%s left %s\n", lr.Username, gamelogic.GameName(w.ID))
+		}
+		if reply.Error == "" && lr.Action == gamelogic.LobbyResume {
+			fmt.Printf("%s resumed %s at turn %d\n", lr.Username, gamelogic.GameName(w.ID), reply.Snapshot.Turn)
+		}
+		err := PublishJSON(aCh, routing.ExchangeDefault, lr.ReplyTo, reply)
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+// HandlerPresence records that a player joined, is still there or left, and
+// tells everyone in the game when that changes whether they are connected.
+func HandlerPresence(w *gamelogic.World, r *gamelogic.Roster) func(gamelogic.Presence, *amqp.Channel) int {
+	return func(p gamelogic.Presence, aCh *amqp.Channel) int {
+		defer fmt.Print("> ")
+		// A player who just left the lobby can still say goodbye.
+		if !w.HasPlayer(p.Username) && p.Kind != gamelogic.PresenceLeave {
+			fmt.Printf("ignoring presence from %s, who is not in %s\n", p.Username, gamelogic.GameName(w.ID))
+			return NackDiscard
+		}
+		pp, changed := r.Record(p, time.Now())
+		if !changed {
+			return Ack
+		}
+		fmt.Printf("%s: %s is %s\n", gamelogic.GameName(w.ID), pp.Username, pp.Status)
+		err := PublishJSON(aCh, routing.ExchangePerilDirect, routing.GameKey(w.ID, routing.RosterKey), pp)
+		if err != nil {
+			return NackRequeue
+		}
+		return Ack
+	}
+}
+
+func HandlerPresenceUpdate(gs *gamelogic.GameState) func(gamelogic.PlayerPresence, *amqp.Channel) int {
+	return func(pp gamelogic.PlayerPresence, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		gs.HandlePresenceUpdate(pp)
+		return Ack
+	}
+}
+
+// HandlerLobbyReply passes replies on to whoever is waiting for them. A reply
+// nobody has room for is dropped rather than holding up the queue; the
+// request it answered has already timed out.
+func HandlerLobbyReply(replies chan<- gamelogic.LobbyReply) func(gamelogic.LobbyReply, *amqp.Channel) int {
+	return func(reply gamelogic.LobbyReply, _ *amqp.Channel) int {
+		select {
+		case replies <- reply:
+		default:
+			fmt.Printf("dropping a lobby reply to %s nobody is waiting for\n", reply.Action)
+		}
+		return Ack
+	}
+}
+
+// The spectator handlers only ever print; a spectator has no player, so
+// nothing it sees can start a war or needs an answer.
+
+func HandlerSpectatorMove(s *gamelogic.Spectator) func(gamelogic.ArmyMove, *amqp.Channel) int {
+	return func(am gamelogic.ArmyMove, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		s.HandleMove(am)
+		return Ack
+	}
+}
+
+func HandlerSpectatorWar(s *gamelogic.Spectator) func(gamelogic.WarSummary, *amqp.Channel) int {
+	return func(ws gamelogic.WarSummary, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		s.HandleWar(ws)
+		return Ack
+	}
+}
+
+func HandlerSpectatorGameLog(s *gamelogic.Spectator) func(routing.GameLog, *amqp.Channel) int {
+	return func(gl routing.GameLog, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		s.HandleGameLog(gl)
+		return Ack
+	}
+}
+
+func HandlerSpectatorPause(s *gamelogic.Spectator) func(routing.PlayingState, *amqp.Channel) int {
+	return func(ps routing.PlayingState, _ *amqp.Channel) int {
+		defer fmt.Print("> ")
+		s.HandlePause(ps)
+		return Ack
+	}
+}
+
+func HandlerWorldSummary(s *gamelogic.Spectator) func(gamelogic.WorldSummary, *amqp.Channel) int {
+	return func(ws gamelogic.WorldSummary, _ *amqp.Channel) int {
 		defer fmt.Print("> ")
-		gamelogic.WriteLog(gl)
+		s.HandleSummary(ws)
 		return Ack
 	}
 }
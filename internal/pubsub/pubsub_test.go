@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// testPlayers sets up a server that signs everything and a registry that
+// knows it and every player, as a client would see them.
+func testPlayers(t *testing.T, usernames ...string) (*identity.Registry, map[string]*identity.Signer) {
+	t.Helper()
+	registry := identity.NewRegistry()
+	signers := map[string]*identity.Signer{}
+	server, err := identity.NewSigner(routing.AuthorityUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Pin(server.Announcement())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers[routing.AuthorityUsername] = server
+	for _, name := range usernames {
+		s, err := identity.NewSigner(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = registry.Register(s.Announcement())
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[name] = s
+	}
+	SetVerifier(registry)
+	t.Cleanup(func() {
+		SetVerifier(nil)
+		SetAuthority(nil)
+	})
+	return registry, signers
+}
+
+// sealedDelivery seals a turn result for to on key, signed by the server.
+func sealedDelivery(t *testing.T, registry *identity.Registry, to, key string, tr gamelogic.TurnResult) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := registry.SealFor(to, key, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedDelivery(t, sealed)
+}
+
+// signedDelivery is what a consumer receives when val is published.
+func signedDelivery[T any](t *testing.T, val T) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{
+		Body:    body,
+		Headers: sign(val, body),
+	}
+}
+
+func TestDeliverSealedTurnResult(t *testing.T) {
+	registry, signers := testPlayers(t, "alice", "bob")
+	SetAuthority(signers[routing.AuthorityUsername])
+	aliceKey := routing.TurnResultsPrefix + ".alice"
+	bobKey := routing.TurnResultsPrefix + ".bob"
+
+	tests := []struct {
+		name    string
+		to      string
+		key     string
+		handled bool
+	}{
+		{name: "sealed for alice", to: "alice", key: aliceKey, handled: true},
+		{name: "bob's view replayed to alice", to: "bob", key: bobKey},
+		{name: "sealed for alice on bob's key", to: "alice", key: bobKey},
+		{name: "sealed for bob on alice's key", to: "bob", key: aliceKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := sealedDelivery(t, registry, tt.to, tt.key, gamelogic.TurnResult{Turn: 7, Viewer: tt.to})
+			handled := false
+			handler := func(tr gamelogic.TurnResult, _ *amqp.Channel) int {
+				handled = true
+				if tr.Turn != 7 || tr.Viewer != "alice" {
+					t.Errorf("handler got %+v", tr)
+				}
+				return Ack
+			}
+			ack := deliver(d, nil, handler, UnmarshallerUnsealed(signers["alice"], aliceKey, UnmarshallerTurnResult()), JSON)
+			if handled != tt.handled {
+				t.Fatalf("handler ran: %v, want %v", handled, tt.handled)
+			}
+			if !tt.handled && ack != NackDiscard {
+				t.Errorf("ack = %v, want NackDiscard", ack)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishSealedJSON publishes val so only to can read it, encrypted to their
+// key in the registry set with SetVerifier. Anyone else bound to the key sees
+// who it is for and nothing more.
+func PublishSealedJSON[T any](ch *amqp.Channel, exchange, key, to string, val T) error {
+	signing.mu.RLock()
+	registry := signing.verifier
+	signing.mu.RUnlock()
+	if registry == nil {
+		return errors.New("there is no registry to find the recipient's key in")
+	}
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	sealed, err := registry.SealFor(to, key, bytes)
+	if err != nil {
+		return err
+	}
+	return PublishJSON(ch, exchange, key, sealed)
+}
+
+// UnmarshallerUnsealed opens payloads sealed for s on key, then decodes them
+// with unmarshaller. A payload sealed for another key is refused, so one
+// view can not be passed off as another.
+func UnmarshallerUnsealed[T any](s *identity.Signer, key string, unmarshaller func([]byte, int) (T, error)) func([]byte, int) (T, error) {
+	unsealed := UnmarshallerSealed()
+	return func(arr []byte, dataType int) (T, error) {
+		var val T
+		sealed, err := unsealed(arr, dataType)
+		if err != nil {
+			return val, err
+		}
+		if sealed.Key != key {
+			return val, fmt.Errorf("payload sealed for %s arrived on %s", sealed.Key, key)
+		}
+		body, err := s.OpenSealed(sealed)
+		if err != nil {
+			return val, err
+		}
+		return unmarshaller(body, JSON)
+	}
+}
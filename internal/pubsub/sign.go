@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	headerSigner    = "x-peril-signer"
+	headerSignature = "x-peril-signature"
+)
+
+// Claimant is implemented by payloads published on behalf of a single
+// player, which that player may sign. Every other payload has to be signed by
+// the server.
+type Claimant interface {
+	Claimant() string
+}
+
+var signing = struct {
+	mu        *sync.RWMutex
+	signers   map[string]*identity.Signer
+	authority *identity.Signer
+	verifier  *identity.Registry
+}{
+	mu:      &sync.RWMutex{},
+	signers: map[string]*identity.Signer{},
+}
+
+// AddSigner makes the publish helpers sign every payload whose claimant is
+// s.Username.
+func AddSigner(s *identity.Signer) {
+	signing.mu.Lock()
+	defer signing.mu.Unlock()
+	signing.signers[s.Username] = s
+}
+
+func RemoveSigner(username string) {
+	signing.mu.Lock()
+	defer signing.mu.Unlock()
+	delete(signing.signers, username)
+}
+
+// SetAuthority makes the publish helpers sign every payload with s, which
+// must be the routing.AuthorityUsername signer.
+func SetAuthority(s *identity.Signer) {
+	signing.mu.Lock()
+	defer signing.mu.Unlock()
+	signing.authority = s
+}
+
+// SetVerifier makes every subscription check the signature of every payload
+// but key announcements. Unsigned or mis-signed deliveries are dead-lettered.
+func SetVerifier(r *identity.Registry) {
+	signing.mu.Lock()
+	defer signing.mu.Unlock()
+	signing.verifier = r
+}
+
+// sign returns the headers carrying the signature of body. With an authority
+// set everything is signed by it; otherwise only payloads with a claimant are,
+// by that claimant's signer. It returns nil when there is nobody to sign.
+func sign[T any](val T, body []byte) amqp.Table {
+	signing.mu.RLock()
+	s := signing.authority
+	if s == nil {
+		if c, ok := any(val).(Claimant); ok {
+			s = signing.signers[c.Claimant()]
+		}
+	}
+	signing.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+	return signedBy(s.Username, s.Sign(body))
+}
+
+// signedBy returns the headers carrying signer's signature.
+func signedBy(signer string, signature []byte) amqp.Table {
+	return amqp.Table{
+		headerSigner:    signer,
+		headerSignature: signature,
+	}
+}
+
+func verify[T any](val T, body []byte, headers amqp.Table) error {
+	signing.mu.RLock()
+	verifier := signing.verifier
+	signing.mu.RUnlock()
+	if verifier == nil {
+		return nil
+	}
+	claimant := routing.AuthorityUsername
+	switch v := any(val).(type) {
+	case routing.PublicKeyAnnouncement:
+		// Announcements are how keys get registered, so there is nothing
+		// to check them against yet.
+		return nil
+	case Claimant:
+		claimant = v.Claimant()
+	}
+
+	signer, ok := headers[headerSigner].(string)
+	if !ok {
+		return errors.New("message is not signed")
+	}
+	signature, ok := headers[headerSignature].([]byte)
+	if !ok {
+		return errors.New("message is not signed")
+	}
+	if signer != claimant && signer != routing.AuthorityUsername {
+		return fmt.Errorf("%s signed a message on behalf of %s", signer, claimant)
+	}
+	return verifier.Verify(signer, body, signature)
+}
@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/identity"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestVerify(t *testing.T) {
+	_, signers := testPlayers(t, "alice", "bob")
+	impostor, err := identity.NewSigner(routing.AuthorityUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	submission := gamelogic.TurnSubmission{Username: "alice", Turn: 3}
+	gameOver := gamelogic.GameOver{Turn: 9, Winners: []string{"alice"}}
+
+	tests := []struct {
+		name    string
+		val     any
+		signer  *identity.Signer
+		tamper  bool
+		wantErr bool
+	}{
+		{name: "signed by its claimant", val: submission, signer: signers["alice"]},
+		{name: "signed by the server for a player", val: submission, signer: signers[routing.AuthorityUsername]},
+		{name: "signed by someone else", val: submission, signer: signers["bob"], wantErr: true},
+		{name: "not signed", val: submission, wantErr: true},
+		{name: "changed after signing", val: submission, signer: signers["alice"], tamper: true, wantErr: true},
+		{name: "server message signed by the server", val: gameOver, signer: signers[routing.AuthorityUsername]},
+		{name: "server message signed by a player", val: gameOver, signer: signers["alice"], wantErr: true},
+		{name: "server message signed by another server key", val: gameOver, signer: impostor, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var headers amqp.Table
+			if tt.signer != nil {
+				headers = signedBy(tt.signer.Username, tt.signer.Sign(body))
+			}
+			if tt.tamper {
+				body = append(body, ' ')
+			}
+			err = verify(tt.val, body, headers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -11,3 +11,42 @@ type GameLog struct {
 	Message     string
 	Username    string
 }
+
+func (gl GameLog) Claimant() string {
+	return gl.Username
+}
+
+type PublicKeyAnnouncement struct {
+	Username      string
+	SigningKey    []byte
+	EncryptionKey []byte
+}
+
+type KeyRegistry struct {
+	Keys []PublicKeyAnnouncement
+}
+
+// Whisper is a private message encrypted for its recipient. Only From and To
+// are readable by anyone else bound to peril_topic.
+type Whisper struct {
+	From         string
+	To           string
+	EphemeralKey []byte
+	Nonce        []byte
+	Ciphertext   []byte
+}
+
+func (w Whisper) Claimant() string {
+	return w.From
+}
+
+// Sealed is a payload encrypted for one player, like a whisper from the
+// server. Key is the routing key it was published on, and is bound into the
+// ciphertext so it can not be replayed onto another.
+type Sealed struct {
+	To           string
+	Key          string
+	EphemeralKey []byte
+	Nonce        []byte
+	Ciphertext   []byte
+}
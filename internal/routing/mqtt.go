@@ -0,0 +1,82 @@
+package routing
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// MQTTTopicRoot is where the bridge republishes everything it sees on
+	// peril_topic.
+	MQTTTopicRoot = "peril"
+
+	// MQTTPublishRoot is where MQTT clients publish messages meant for
+	// peril_topic. Keeping it apart from MQTTTopicRoot stops the bridge from
+	// echoing its own messages back into RabbitMQ.
+	MQTTPublishRoot = "peril-in"
+)
+
+// SignedMessage is what MQTT clients publish under MQTTPublishRoot. Body is the
+// JSON payload and Signature the ed25519 signature Signer made of exactly
+// those bytes. The bridge passes the signature on, so the server checks it
+// just as it would for a client talking to RabbitMQ directly.
+type SignedMessage struct {
+	Signer    string
+	Signature []byte
+	Body      json.RawMessage
+}
+
+// ToMQTTTopic maps a routing key (or binding pattern) such as "army_moves.*"
+// to an MQTT topic (or filter) under root, e.g. "peril/army_moves/+". "#"
+// means the same to both, so it is kept as it is.
+func ToMQTTTopic(root, key string) string {
+	words := strings.Split(key, ".")
+	for i, w := range words {
+		if w == "*" {
+			words[i] = "+"
+		}
+	}
+	return root + "/" + strings.Join(words, "/")
+}
+
+// FromMQTTTopic maps an MQTT topic (or filter) under root back to a routing
+// key (or binding pattern). ok is false when the topic is outside root.
+func FromMQTTTopic(root, topic string) (key string, ok bool) {
+	rest, ok := strings.CutPrefix(topic, root+"/")
+	if !ok || rest == "" {
+		return "", false
+	}
+	words := strings.Split(rest, "/")
+	for i, w := range words {
+		if w == "+" {
+			words[i] = "*"
+		}
+	}
+	return strings.Join(words, "."), true
+}
+
+// MatchMQTTTopic reports whether topic matches filter using MQTT's "+" (one
+// level) and "#" (all remaining levels) wildcards.
+func MatchMQTTTopic(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+// KeySuffix returns the last word of a routing key, which for per-player keys
+// like "army_moves.<user>" is the username.
+func KeySuffix(key string) string {
+	i := strings.LastIndex(key, ".")
+	return key[i+1:]
+}
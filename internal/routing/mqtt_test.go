@@ -0,0 +1,78 @@
+package routing
+
+import "testing"
+
+func TestToMQTTTopic(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "army_moves.alice", want: "peril/army_moves/alice"},
+		{key: "army_moves.*", want: "peril/army_moves/+"},
+		{key: "game.friday.*.alice", want: "peril/game/friday/+/alice"},
+		{key: "army_moves.#", want: "peril/army_moves/#"},
+		{key: "#", want: "peril/#"},
+	}
+	for _, tt := range tests {
+		got := ToMQTTTopic(MQTTTopicRoot, tt.key)
+		if got != tt.want {
+			t.Errorf("ToMQTTTopic(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestFromMQTTTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+		ok    bool
+	}{
+		{topic: "peril-in/spawn_orders/alice", want: "spawn_orders.alice", ok: true},
+		{topic: "peril-in/spawn_orders/+", want: "spawn_orders.*", ok: true},
+		{topic: "peril-in/spawn_orders/#", want: "spawn_orders.#", ok: true},
+		{topic: "peril-in/+/alice", want: "*.alice", ok: true},
+		{topic: "peril/spawn_orders/alice"},
+		{topic: "peril-in/"},
+		{topic: "peril-in"},
+	}
+	for _, tt := range tests {
+		got, ok := FromMQTTTopic(MQTTPublishRoot, tt.topic)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("FromMQTTTopic(%q) = %q, %v, want %q, %v", tt.topic, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMQTTTopicRoundTrip(t *testing.T) {
+	for _, key := range []string{"army_moves.alice", "army_moves.*", "game.friday.presence.*", "treaties.#"} {
+		got, ok := FromMQTTTopic(MQTTTopicRoot, ToMQTTTopic(MQTTTopicRoot, key))
+		if !ok || got != key {
+			t.Errorf("round trip of %q gave %q, %v", key, got, ok)
+		}
+	}
+}
+
+func TestMatchMQTTTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{filter: "peril/army_moves/alice", topic: "peril/army_moves/alice", want: true},
+		{filter: "peril/army_moves/alice", topic: "peril/army_moves/bob", want: false},
+		{filter: "peril/army_moves/+", topic: "peril/army_moves/bob", want: true},
+		{filter: "peril/army_moves/+", topic: "peril/army_moves", want: false},
+		{filter: "peril/army_moves/+", topic: "peril/army_moves/bob/extra", want: false},
+		{filter: "peril/+/bob", topic: "peril/treaties/bob", want: true},
+		{filter: "peril/#", topic: "peril/army_moves/bob", want: true},
+		{filter: "peril/army_moves/#", topic: "peril/army_moves/bob/extra", want: true},
+		{filter: "peril/#", topic: "peril-in/army_moves/bob", want: false},
+		{filter: "#", topic: "anything/at/all", want: true},
+	}
+	for _, tt := range tests {
+		got := MatchMQTTTopic(tt.filter, tt.topic)
+		if got != tt.want {
+			t.Errorf("MatchMQTTTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
@@ -8,9 +8,73 @@ const (
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	KeyAnnouncementsPrefix = "keys"
+
+	KeyRegistryKey = "key_registry"
+
+	DiplomacyPrefix = "diplomacy"
+
+	SpawnOrdersPrefix = "spawn_orders"
+
+	MoveOrdersPrefix = "move_orders"
+
+	PromotionOrdersPrefix = "promotion_orders"
+
+	StateDeltasPrefix = "state"
+
+	RulesetKey = "ruleset"
+
+	TurnSubmissionsPrefix = "turn_submissions"
+
+	TurnResultsPrefix = "turn_results"
+
+	GameOverKey = "game_over"
+
+	DiplomaticActionsPrefix = "diplomatic_actions"
+
+	TreatiesPrefix = "treaties"
+
+	BattleWarningsPrefix = "battle_warnings"
+
+	BattleChoicesPrefix = "battle_choices"
+
+	LobbyPrefix = "lobby"
+
+	PresencePrefix = "presence"
+
+	RosterKey = "roster"
+
+	WorldSummaryKey = "world_summary"
+
+	SpectatorsPrefix = "spectators"
+
+	GamePrefix = "game"
 )
 
+// GameKey namespaces a routing key, binding pattern or queue name to one game,
+// so a single broker can host many games at once. The default game has an
+// empty ID and keeps the bare keys.
+func GameKey(gameID, key string) string {
+	if gameID == "" {
+		return key
+	}
+	return GamePrefix + "." + gameID + "." + key
+}
+
+// AuthorityUsername is who the server signs as. Its signature is accepted on
+// any payload.
+const AuthorityUsername = "Server"
+
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	// ExchangeDefault routes every message straight to the queue its key
+	// names.
+	ExchangeDefault = ""
 )
+
+// PrivateQueuePrefix starts the name of every queue the broker names itself.
+// Clients cannot declare queues with it, so a queue named like this belongs
+// to whoever had the broker declare it.
+const PrivateQueuePrefix = "amq.gen-"